@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Audit actions, named <subject>.<verb>
+const (
+	auditLoginSucceeded       = "login.succeeded"
+	auditLoginFailed          = "login.failed"
+	auditTokenRefreshed       = "token.refreshed"
+	auditTokenRevoked         = "token.revoked"
+	auditUserUpdated          = "user.updated"
+	auditChirpDeleted         = "chirp.deleted"
+	auditAdminReset           = "admin.reset"
+	auditAdminPasswordReset   = "admin.password_reset"
+	auditAdminSessionsRevoked = "admin.sessions_revoked"
+	auditAdminSuspended       = "admin.suspended"
+	auditAdminUnsuspended     = "admin.unsuspended"
+	auditAdminChirpyRed       = "admin.chirpy_red"
+	auditAdminRoleChanged     = "admin.role_changed"
+	auditAdminWebhookReplayed = "admin.webhook_replayed"
+	auditAdminOutboxRequeued  = "admin.outbox_requeued"
+)
+
+// auditWebhookPrefix is followed by the provider's event type, e.g.
+// "webhook.user.upgraded"
+const auditWebhookPrefix = "webhook."
+
+// Kinds of thing an audit event can be about
+const (
+	auditTargetUser         = "user"
+	auditTargetChirp        = "chirp"
+	auditTargetSession      = "session"
+	auditTargetEmail        = "email"
+	auditTargetWebhookEvent = "webhook_event"
+	auditTargetOutboxEvent  = "outbox_event"
+)
+
+// auditActor is the actor for events done by a known user
+func auditActor(userID uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: userID, Valid: true}
+}
+
+// recordAudit appends an event to the audit log. Failing to write it is
+// logged but never fails the request, and it's written even if the client
+// has already gone away.
+func (cfg *apiConfig) recordAudit(r *http.Request, actorID uuid.NullUUID, action, targetType, targetID string) {
+	err := cfg.dbQueries.CreateAuditEvent(context.WithoutCancel(r.Context()), database.CreateAuditEventParams{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IpAddress:  getClientIP(r),
+		RequestID:  requestIDFromContext(r.Context()),
+	})
+	if err != nil {
+		log.Printf("Couldn't record audit event %s: %s", action, err)
+	}
+}
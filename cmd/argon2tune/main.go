@@ -0,0 +1,72 @@
+// argon2tune times argon2id on this machine and prints the parameters that
+// keep a single password hash just above a target latency. Run it on the
+// hardware the server runs on and copy the output into .env.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+)
+
+func main() {
+	target := flag.Duration("target", 250*time.Millisecond, "how long one hash should take")
+	memory := flag.Uint("memory", 64*1024, "memory per hash in KiB")
+	parallelism := flag.Uint("parallelism", 2, "threads per hash")
+	maxIterations := flag.Uint("max-iterations", 20, "give up above this many iterations")
+	samples := flag.Int("samples", 5, "hashes timed per candidate, the median counts")
+	flag.Parse()
+
+	if *parallelism < 1 || *parallelism > 255 {
+		log.Fatal("parallelism must be between 1 and 255")
+	}
+	if *samples < 1 {
+		log.Fatal("samples must be at least 1")
+	}
+
+	params := argon2id.Params{
+		Memory:      uint32(*memory),
+		Parallelism: uint8(*parallelism),
+		SaltLength:  argon2id.DefaultParams.SaltLength,
+		KeyLength:   argon2id.DefaultParams.KeyLength,
+	}
+
+	// Memory is the stronger defence against GPUs, so it stays fixed and
+	// iterations are raised until the target is reached
+	var elapsed time.Duration
+	for params.Iterations = 1; params.Iterations <= uint32(*maxIterations); params.Iterations++ {
+		elapsed = median(params, *samples)
+		fmt.Printf("m=%d t=%d p=%d: %s\n", params.Memory, params.Iterations, params.Parallelism, elapsed.Round(time.Millisecond))
+		if elapsed >= *target {
+			break
+		}
+	}
+
+	if params.Iterations > uint32(*maxIterations) {
+		params.Iterations = uint32(*maxIterations)
+		fmt.Printf("\nTarget not reached with %d iterations, consider raising -memory\n", *maxIterations)
+	} else if params.Iterations == 1 && elapsed > 2*(*target) {
+		fmt.Printf("\nOne iteration already takes %s, consider lowering -memory\n", elapsed.Round(time.Millisecond))
+	}
+
+	fmt.Printf("\nARGON2_MEMORY=%d\nARGON2_ITERATIONS=%d\nARGON2_PARALLELISM=%d\n", params.Memory, params.Iterations, params.Parallelism)
+}
+
+func median(params argon2id.Params, samples int) time.Duration {
+	durations := make([]time.Duration, samples)
+	for i := range durations {
+		start := time.Now()
+		_, err := argon2id.CreateHash("argon2tune-benchmark", &params)
+		if err != nil {
+			log.Fatalf("Error hashing: %s", err)
+		}
+		durations[i] = time.Since(start)
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[samples/2]
+}
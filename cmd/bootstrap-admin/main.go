@@ -0,0 +1,67 @@
+// bootstrap-admin promotes a user to admin, so a fresh deployment has
+// someone who can use the /admin routes. Without -email it promotes the
+// oldest account. It refuses to run once an admin exists unless -force is
+// given, after that admins are managed with PUT /admin/users/{userID}/role.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the user to promote, defaults to the first user who signed up")
+	force := flag.Bool("force", false, "promote even if an admin already exists")
+	flag.Parse()
+
+	godotenv.Load()
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		log.Fatal("DB_URL must be set")
+	}
+
+	dbConn, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	defer dbConn.Close()
+
+	queries := database.New(dbConn)
+	ctx := context.Background()
+
+	admins, err := queries.CountUsersWithRole(ctx, auth.RoleAdmin)
+	if err != nil {
+		log.Fatalf("Error counting admins: %s", err)
+	}
+	if admins > 0 && !*force {
+		log.Fatalf("There already are %d admins, use -force to promote another one", admins)
+	}
+
+	var user database.User
+	if *email != "" {
+		user, err = queries.GetUserByEmail(ctx, *email)
+	} else {
+		user, err = queries.GetOldestUser(ctx)
+	}
+	if err != nil {
+		log.Fatalf("Error finding the user to promote: %s", err)
+	}
+
+	_, err = queries.UpdateUserRole(ctx, database.UpdateUserRoleParams{
+		Role: auth.RoleAdmin,
+		ID:   user.ID,
+	})
+	if err != nil {
+		log.Fatalf("Error promoting user: %s", err)
+	}
+
+	log.Printf("%s is now an admin, log in again to get a token with the new role\n", user.Email)
+}
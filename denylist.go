@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// denylistStore adapts the generated queries to auth.DenylistStore
+type denylistStore struct {
+	db *database.Queries
+}
+
+func (s denylistStore) RevokeAccessToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error {
+	return s.db.RevokeAccessToken(ctx, database.RevokeAccessTokenParams{
+		Jti:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (s denylistStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return s.db.IsAccessTokenRevoked(ctx, jti)
+}
+
+// pruneRevokedAccessTokens deletes denylist rows for tokens that have
+// expired anyway, so the table doesn't grow forever
+func (cfg *apiConfig) pruneRevokedAccessTokens(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := cfg.dbQueries.DeleteExpiredRevokedAccessTokens(context.Background())
+		if err != nil {
+			log.Printf("Error pruning revoked access tokens: %s", err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/events"
+	"github.com/google/uuid"
+)
+
+// Domain events written to the outbox
+const (
+	eventUserCreated           = "user.created"
+	eventUserUpdated           = "user.updated"
+	eventUserSuspended         = "user.suspended"
+	eventUserUnsuspended       = "user.unsuspended"
+	eventUserRoleChanged       = "user.role_changed"
+	eventUserDeletionScheduled = "user.deletion_scheduled"
+	eventUserDeletionCanceled  = "user.deletion_canceled"
+	eventUserDeleted           = "user.deleted"
+	eventChirpCreated          = "chirp.created"
+	eventChirpDeleted          = "chirp.deleted"
+	// eventSubscriptionPrefix is followed by the kind of change, e.g.
+	// "subscription.activated"
+	eventSubscriptionPrefix = "subscription."
+)
+
+// Aggregates the events are about
+const (
+	aggregateUser  = "user"
+	aggregateChirp = "chirp"
+)
+
+const (
+	outboxBatchSize = 100
+	// outboxRetention is how long published events are kept around
+	outboxRetention = 7 * 24 * time.Hour
+	// outboxMaxBackoff caps the wait after the relay failed to publish
+	outboxMaxBackoff = time.Minute
+	// outboxMaxAttempts is how often an event is tried before the relay
+	// gives up on it, about 20 minutes at the capped backoff
+	outboxMaxAttempts = 20
+)
+
+// eventSink is a sink the relay publishes to, named so the outbox can
+// remember which sinks already have an event
+type eventSink struct {
+	name string
+	sink events.Sink
+}
+
+// inTx runs fn in a transaction, committing if it returns nil
+func (cfg *apiConfig) inTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(cfg.dbQueries.WithTx(tx))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordDomainEvent writes an event to the outbox. q should be bound to the
+// transaction making the change, so the event exists if and only if the
+// change does.
+func recordDomainEvent(ctx context.Context, q *database.Queries, eventType, aggregateType, aggregateID string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return q.CreateOutboxEvent(ctx, database.CreateOutboxEventParams{
+		EventID:       uuid.New(),
+		EventType:     eventType,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Payload:       string(payload),
+		OccurredAt:    time.Now().UTC(),
+	})
+}
+
+// relayOutbox publishes outbox events to every sink, oldest first. An event
+// is only marked published once all sinks took it, so each sink sees it at
+// least once. A failure stops the batch to keep events in order, and the
+// relay backs off until the sink recovers. After outboxMaxAttempts the
+// event is marked failed and skipped, so one bad event can't block the
+// rest; admins can list and requeue failed events.
+func (cfg *apiConfig) relayOutbox(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var retryAt time.Time
+	failures := 0
+	for range ticker.C {
+		if time.Now().Before(retryAt) {
+			continue
+		}
+
+		for {
+			published, err := cfg.relayOutboxBatch(context.Background())
+			if err != nil {
+				failures++
+				backoff := min(interval<<min(failures, 10), outboxMaxBackoff)
+				retryAt = time.Now().Add(backoff)
+				log.Printf("Error relaying outbox events, retrying in %s: %s", backoff, err)
+				break
+			}
+			failures = 0
+			if published < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// relayOutboxBatch publishes one batch of events, returning how many made it
+// to every sink. Progress is committed even when a sink fails part way.
+func (cfg *apiConfig) relayOutboxBatch(ctx context.Context) (int, error) {
+	published := 0
+	var publishErr error
+	err := cfg.inTx(ctx, func(q *database.Queries) error {
+		rows, err := q.LockUnpublishedOutboxEvents(ctx, outboxBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			publishedTo, err := cfg.publishOutboxEvent(ctx, row)
+			if err != nil && row.Attempts+1 >= outboxMaxAttempts {
+				log.Printf("Giving up on outbox event %s after %d attempts: %s", row.EventID, row.Attempts+1, err)
+				err = q.MarkOutboxEventFailed(ctx, database.MarkOutboxEventFailedParams{
+					ID:          row.ID,
+					PublishedTo: publishedTo,
+					LastError:   err.Error(),
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				publishErr = err
+				return q.RecordOutboxEventFailure(ctx, database.RecordOutboxEventFailureParams{
+					ID:          row.ID,
+					PublishedTo: publishedTo,
+					LastError:   err.Error(),
+				})
+			}
+
+			err = q.MarkOutboxEventPublished(ctx, database.MarkOutboxEventPublishedParams{
+				ID:          row.ID,
+				PublishedTo: publishedTo,
+			})
+			if err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
+
+// publishOutboxEvent hands an event to the sinks that don't have it yet and
+// returns the updated list of sinks that do
+func (cfg *apiConfig) publishOutboxEvent(ctx context.Context, row database.Outbox) (string, error) {
+	event := events.Event{
+		ID:            row.EventID,
+		Type:          row.EventType,
+		AggregateType: row.AggregateType,
+		AggregateID:   row.AggregateID,
+		OccurredAt:    row.OccurredAt,
+		Data:          json.RawMessage(row.Payload),
+	}
+
+	publishedTo := strings.Fields(row.PublishedTo)
+	for _, sink := range cfg.eventSinks {
+		if slices.Contains(publishedTo, sink.name) {
+			continue
+		}
+		err := sink.sink.Publish(ctx, event)
+		if err != nil {
+			return strings.Join(publishedTo, " "), fmt.Errorf("%s: %w", sink.name, err)
+		}
+		publishedTo = append(publishedTo, sink.name)
+	}
+	return strings.Join(publishedTo, " "), nil
+}
+
+// pruneOutbox deletes events published longer ago than outboxRetention
+func (cfg *apiConfig) pruneOutbox(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().UTC().Add(-outboxRetention)
+		n, err := cfg.dbQueries.DeletePublishedOutboxEvents(context.Background(), sql.NullTime{Time: cutoff, Valid: true})
+		if err != nil {
+			log.Printf("Error pruning outbox: %s", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Pruned %d published outbox events\n", n)
+		}
+	}
+}
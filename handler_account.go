@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// accountDeletionGracePeriod is how long a deleted account can still be
+// brought back by logging in
+const accountDeletionGracePeriod = 30 * 24 * time.Hour
+
+type accountExport struct {
+	Profile      User              `json:"profile"`
+	Chirps       []Chirp           `json:"chirps"`
+	Sessions     []Session         `json:"sessions"`
+	Subscription *Subscription     `json:"subscription"`
+	APIKeys      []exportedAPIKey  `json:"api_keys"`
+	OAuthClients []OAuthClient     `json:"oauth_clients"`
+	OAuthGrants  []OAuthGrant      `json:"oauth_grants"`
+	Webhooks     []exportedWebhook `json:"webhooks"`
+}
+
+// exportedWebhook is a webhook endpoint with its whole delivery log, the
+// signing secret is left out
+type exportedWebhook struct {
+	WebhookSubscription
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// exportedAPIKey is an API key as listed, plus whether it was revoked.
+// Only a hash of the key itself is stored.
+type exportedAPIKey struct {
+	APIKey
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// OAuthGrant is an authorization the user gave an OAuth client, without
+// the code and PKCE challenge
+type OAuthGrant struct {
+	ClientID    string     `json:"client_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RedirectURI string     `json:"redirect_uri"`
+	Scope       string     `json:"scope"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+}
+
+func (cfg *apiConfig) handlerUsersExport(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateRequest(w, r, auth.ScopeUsersRead)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		respondWithError(w, http.StatusBadRequest, "Format must be zip or json", nil)
+		return
+	}
+
+	// Step 1: Gather everything before writing, so errors can still be a 500
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return
+	}
+
+	dbChirps, err := cfg.dbQueries.GetAllChirpsByAuthor(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+		return
+	}
+
+	dbSessions, err := cfg.dbQueries.GetSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+
+	var subscription *Subscription
+	dbSubscription, err := cfg.dbQueries.GetSubscriptionForUser(r.Context(), user.ID)
+	if err == nil {
+		converted := convertSubscription(dbSubscription)
+		subscription = &converted
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get subscription", err)
+		return
+	}
+
+	dbAPIKeys, err := cfg.dbQueries.GetAPIKeysForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+
+	dbClients, err := cfg.dbQueries.GetOAuthClientsForOwner(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get OAuth clients", err)
+		return
+	}
+
+	dbGrants, err := cfg.dbQueries.GetOAuthAuthorizationCodesForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get OAuth grants", err)
+		return
+	}
+
+	dbWebhooks, err := cfg.dbQueries.GetWebhookSubscriptionsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhooks", err)
+		return
+	}
+
+	dbDeliveries, err := cfg.dbQueries.GetWebhookDeliveriesForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook deliveries", err)
+		return
+	}
+
+	export := accountExport{
+		Profile: User{
+			ID:               user.ID,
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+			Email:            user.Email,
+			IsChirpyRed:      user.IsChirpyRed,
+			EmailVerified:    user.EmailVerifiedAt.Valid,
+			TwoFactorEnabled: user.TotpEnabledAt.Valid,
+			Role:             user.Role,
+		},
+		Chirps:       []Chirp{},
+		Sessions:     []Session{},
+		Subscription: subscription,
+		APIKeys:      []exportedAPIKey{},
+		OAuthClients: []OAuthClient{},
+		OAuthGrants:  []OAuthGrant{},
+		Webhooks:     []exportedWebhook{},
+	}
+	for _, chirp := range dbChirps {
+		export.Chirps = append(export.Chirps, convertChirp(chirp))
+	}
+	// Revoked and expired sessions are included, the refresh tokens aren't
+	for _, session := range dbSessions {
+		export.Sessions = append(export.Sessions, Session{
+			ID:         session.ID,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IpAddress,
+			ClientID:   session.ClientID.String,
+		})
+	}
+
+	for _, key := range dbAPIKeys {
+		exported := exportedAPIKey{APIKey: convertAPIKey(key)}
+		if key.RevokedAt.Valid {
+			exported.RevokedAt = &key.RevokedAt.Time
+		}
+		export.APIKeys = append(export.APIKeys, exported)
+	}
+	for _, client := range dbClients {
+		export.OAuthClients = append(export.OAuthClients, convertOAuthClient(client))
+	}
+	for _, grant := range dbGrants {
+		converted := OAuthGrant{
+			ClientID:    grant.ClientID,
+			CreatedAt:   grant.CreatedAt,
+			RedirectURI: grant.RedirectUri,
+			Scope:       grant.Scope,
+			ExpiresAt:   grant.ExpiresAt,
+		}
+		if grant.UsedAt.Valid {
+			converted.UsedAt = &grant.UsedAt.Time
+		}
+		export.OAuthGrants = append(export.OAuthGrants, converted)
+	}
+
+	deliveries := map[uuid.UUID][]WebhookDelivery{}
+	for _, delivery := range dbDeliveries {
+		deliveries[delivery.SubscriptionID] = append(deliveries[delivery.SubscriptionID], convertWebhookDelivery(delivery))
+	}
+	for _, webhook := range dbWebhooks {
+		exported := exportedWebhook{
+			WebhookSubscription: convertWebhookSubscription(webhook),
+			Deliveries:          deliveries[webhook.ID],
+		}
+		if exported.Deliveries == nil {
+			exported.Deliveries = []WebhookDelivery{}
+		}
+		export.Webhooks = append(export.Webhooks, exported)
+	}
+
+	// Step 2: Stream the archive
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.`+format+`"`)
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(export)
+		if err != nil {
+			log.Printf("Error writing export: %s", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data any
+	}{
+		{name: "profile.json", data: export.Profile},
+		{name: "chirps.json", data: export.Chirps},
+		{name: "sessions.json", data: export.Sessions},
+		{name: "subscription.json", data: export.Subscription},
+		{name: "api_keys.json", data: export.APIKeys},
+		{name: "oauth_clients.json", data: export.OAuthClients},
+		{name: "oauth_grants.json", data: export.OAuthGrants},
+		{name: "webhooks.json", data: export.Webhooks},
+	}
+	for _, f := range files {
+		file, err := archive.Create(f.name)
+		if err != nil {
+			log.Printf("Error writing export: %s", err)
+			return
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(f.data)
+		if err != nil {
+			log.Printf("Error writing export: %s", err)
+			return
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		log.Printf("Error writing export: %s", err)
+	}
+}
+
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	type response struct {
+		DeleteAfter time.Time `json:"delete_after"`
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return
+	}
+
+	// Step 1: Confirm it's really the owner, guesses count like failed logins
+	err = cfg.passwordPolicy.CheckLength(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if !cfg.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+
+	ok, err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	if err != nil || !ok {
+		respondWithError(w, 401, "Incorrect password", err)
+		return
+	}
+	cfg.releaseLoginAttempt(r.Context(), user.Email, getClientIP(r))
+
+	// Step 2: Schedule the deletion, the account stays until the grace
+	// period is over
+	deleteAfter := time.Now().UTC().Add(accountDeletionGracePeriod)
+	err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+		err := q.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+			DeleteAfter: sql.NullTime{Time: deleteAfter, Valid: true},
+			ID:          user.ID,
+		})
+		if err != nil {
+			return err
+		}
+		user.DeleteAfter = sql.NullTime{Time: deleteAfter, Valid: true}
+		return recordDomainEvent(r.Context(), q, eventUserDeletionScheduled, aggregateUser, user.ID.String(), convertAdminUser(user))
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+
+	// Step 3: Log out everywhere and stop API keys, logging in again
+	// cancels the deletion but keys stay revoked
+	err = cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	err = cfg.dbQueries.RevokeAllAPIKeysForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API keys", err)
+		return
+	}
+
+	err = cfg.denylist.Revoke(r.Context(), claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, response{
+		DeleteAfter: deleteAfter,
+	})
+}
+
+// cancelAccountDeletion is called on every successful login
+func (cfg *apiConfig) cancelAccountDeletion(ctx context.Context, user database.User) {
+	if !user.DeleteAfter.Valid {
+		return
+	}
+
+	err := cfg.inTx(ctx, func(q *database.Queries) error {
+		rows, err := q.CancelUserDeletion(ctx, user.ID)
+		if err != nil || rows == 0 {
+			return err
+		}
+		user.DeleteAfter = sql.NullTime{}
+		return recordDomainEvent(ctx, q, eventUserDeletionCanceled, aggregateUser, user.ID.String(), convertAdminUser(user))
+	})
+	if err != nil {
+		log.Printf("Error cancelling account deletion: %s", err)
+	}
+}
+
+// deleteUser deletes a user in q's transaction. Their chirps and tokens go
+// with them through ON DELETE CASCADE, which skips the handlers writing
+// events, so chirp.deleted is written for every chirp here.
+func deleteUser(ctx context.Context, q *database.Queries, user database.User) error {
+	chirps, err := q.GetAllChirpsByAuthor(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		err = recordDomainEvent(ctx, q, eventChirpDeleted, aggregateChirp, chirp.ID.String(), convertChirp(chirp))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordDomainEvent(ctx, q, eventUserDeleted, aggregateUser, user.ID.String(), convertAdminUser(user))
+	if err != nil {
+		return err
+	}
+	return q.DeleteUser(ctx, user.ID)
+}
+
+// purgeDeletedUsers removes accounts whose grace period is over
+func (cfg *apiConfig) purgeDeletedUsers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		now := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		purged := 0
+		err := cfg.inTx(ctx, func(q *database.Queries) error {
+			users, err := q.ListScheduledUserDeletions(ctx, now)
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				err = deleteUser(ctx, q, user)
+				if err != nil {
+					return err
+				}
+			}
+			purged = len(users)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error purging deleted users: %s", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted users\n", purged)
+		}
+	}
+}
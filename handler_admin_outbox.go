@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type OutboxEvent struct {
+	ID            uuid.UUID       `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Data          json.RawMessage `json:"data"`
+	PublishedTo   []string        `json:"published_to"`
+	Attempts      int32           `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	FailedAt      *time.Time      `json:"failed_at"`
+}
+
+func convertOutboxEvent(row database.Outbox) OutboxEvent {
+	converted := OutboxEvent{
+		ID:            row.EventID,
+		Type:          row.EventType,
+		AggregateType: row.AggregateType,
+		AggregateID:   row.AggregateID,
+		OccurredAt:    row.OccurredAt,
+		Data:          json.RawMessage(row.Payload),
+		PublishedTo:   strings.Fields(row.PublishedTo),
+		Attempts:      row.Attempts,
+		LastError:     row.LastError,
+	}
+	if row.FailedAt.Valid {
+		converted.FailedAt = &row.FailedAt.Time
+	}
+	return converted
+}
+
+// handlerAdminOutboxFailed lists the domain events the relay gave up on,
+// oldest first
+func (cfg *apiConfig) handlerAdminOutboxFailed(w http.ResponseWriter, r *http.Request) {
+	limit := webhookEventsDefaultLimit
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > webhookEventsMaxLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(webhookEventsMaxLimit), err)
+			return
+		}
+	}
+
+	rows, err := cfg.dbQueries.ListFailedOutboxEvents(r.Context(), int32(limit))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list failed events", err)
+		return
+	}
+
+	events := []OutboxEvent{}
+	for _, row := range rows {
+		events = append(events, convertOutboxEvent(row))
+	}
+
+	respondWithJSON(w, 200, events)
+}
+
+// handlerAdminOutboxRequeue hands a failed domain event back to the relay,
+// e.g. once the sink that refused it is fixed. Sinks that already took it
+// don't get it again.
+func (cfg *apiConfig) handlerAdminOutboxRequeue(w http.ResponseWriter, r *http.Request) {
+	eventID, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return
+	}
+
+	row, err := cfg.dbQueries.RequeueOutboxEvent(r.Context(), eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "No failed event with that ID", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't requeue event", err)
+		return
+	}
+
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminOutboxRequeued, auditTargetOutboxEvent, eventID.String())
+
+	respondWithJSON(w, 200, convertOutboxEvent(row))
+}
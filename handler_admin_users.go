@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/payments"
+	"github.com/google/uuid"
+)
+
+const (
+	adminUserSearchDefaultLimit = 50
+	adminUserSearchMaxLimit     = 500
+)
+
+// AdminUser is what operators see of an account, more than the user does
+type AdminUser struct {
+	User
+	SuspendedAt *time.Time `json:"suspended_at"`
+	DeleteAfter *time.Time `json:"delete_after"`
+}
+
+func convertAdminUser(user database.User) AdminUser {
+	converted := AdminUser{
+		User: User{
+			ID:               user.ID,
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+			Email:            user.Email,
+			IsChirpyRed:      user.IsChirpyRed,
+			EmailVerified:    user.EmailVerifiedAt.Valid,
+			TwoFactorEnabled: user.TotpEnabledAt.Valid,
+			Role:             user.Role,
+		},
+	}
+	if user.SuspendedAt.Valid {
+		converted.SuspendedAt = &user.SuspendedAt.Time
+	}
+	if user.DeleteAfter.Valid {
+		converted.DeleteAfter = &user.DeleteAfter.Time
+	}
+	return converted
+}
+
+// adminTargetUser loads the user named by {userID}. With modify set, a
+// moderator may only act on plain users, admins may act on anyone. On
+// failure it has already written the response.
+func (cfg *apiConfig) adminTargetUser(w http.ResponseWriter, r *http.Request, modify bool) (database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return database.User{}, false
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return database.User{}, false
+	}
+
+	actor := actorFromContext(r.Context())
+	if modify && !auth.RoleAtLeast(actor.Role, auth.RoleAdmin) && user.Role != auth.RoleUser {
+		respondWithError(w, 403, "Only admins can change moderators and admins", nil)
+		return database.User{}, false
+	}
+
+	return user, true
+}
+
+func (cfg *apiConfig) handlerAdminUsersSearch(w http.ResponseWriter, r *http.Request) {
+	limit := adminUserSearchDefaultLimit
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > adminUserSearchMaxLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(adminUserSearchMaxLimit), err)
+			return
+		}
+	}
+
+	// A prefix match, with LIKE wildcards in the input taken literally
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	pattern := escaper.Replace(r.URL.Query().Get("email")) + "%"
+
+	dbUsers, err := cfg.dbQueries.SearchUsersByEmail(r.Context(), database.SearchUsersByEmailParams{
+		Email: pattern,
+		Limit: int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search users", err)
+		return
+	}
+
+	users := []AdminUser{}
+	for _, user := range dbUsers {
+		users = append(users, convertAdminUser(user))
+	}
+
+	respondWithJSON(w, 200, users)
+}
+
+func (cfg *apiConfig) handlerAdminUsersGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		AdminUser
+		ChirpCount     int64 `json:"chirp_count"`
+		ActiveSessions int64 `json:"active_sessions"`
+	}
+
+	user, ok := cfg.adminTargetUser(w, r, false)
+	if !ok {
+		return
+	}
+
+	chirpCount, err := cfg.dbQueries.CountChirpsByAuthor(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count chirps", err)
+		return
+	}
+
+	activeSessions, err := cfg.dbQueries.CountActiveSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count sessions", err)
+		return
+	}
+
+	respondWithJSON(w, 200, response{
+		AdminUser:      convertAdminUser(user),
+		ChirpCount:     chirpCount,
+		ActiveSessions: activeSessions,
+	})
+}
+
+// handlerAdminUsersPasswordReset locks the current password out and emails
+// the user a reset link, for accounts that look compromised
+func (cfg *apiConfig) handlerAdminUsersPasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r, true)
+	if !ok {
+		return
+	}
+
+	// Step 1: Replace the password with one nobody knows
+	randomPassword, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate password", err)
+		return
+	}
+
+	hashedPass, err := auth.HashPassword(randomPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPass,
+		ID:             user.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	// Step 2: Log out everywhere and send the reset link
+	err = cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	cfg.queueEmail("password reset", func() { cfg.sendPasswordResetEmail(user.Email) })
+
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminPasswordReset, auditTargetUser, user.ID.String())
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerAdminUsersRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r, true)
+	if !ok {
+		return
+	}
+
+	err := cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminSessionsRevoked, auditTargetUser, user.ID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminUsersSuspend blocks logins, refreshes and API keys. Access
+// tokens already issued run out within the hour.
+func (cfg *apiConfig) handlerAdminUsersSuspend(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r, true)
+	if !ok {
+		return
+	}
+
+	if user.ID == claimsFromContext(r.Context()).UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't suspend yourself", nil)
+		return
+	}
+
+	err := cfg.inTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		user, err = q.SuspendUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		return recordDomainEvent(r.Context(), q, eventUserSuspended, aggregateUser, user.ID.String(), convertAdminUser(user))
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user", err)
+		return
+	}
+
+	err = cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminSuspended, auditTargetUser, user.ID.String())
+
+	respondWithJSON(w, 200, convertAdminUser(user))
+}
+
+func (cfg *apiConfig) handlerAdminUsersUnsuspend(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r, true)
+	if !ok {
+		return
+	}
+
+	err := cfg.inTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		user, err = q.UnsuspendUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		return recordDomainEvent(r.Context(), q, eventUserUnsuspended, aggregateUser, user.ID.String(), convertAdminUser(user))
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unsuspend user", err)
+		return
+	}
+
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminUnsuspended, auditTargetUser, user.ID.String())
+
+	respondWithJSON(w, 200, convertAdminUser(user))
+}
+
+func (cfg *apiConfig) handlerAdminUsersChirpyRed(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IsChirpyRed bool `json:"is_chirpy_red"`
+	}
+
+	user, ok := cfg.adminTargetUser(w, r, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Chirpy Red follows the subscription, so grant a subscription without
+	// an end date or end the current one
+	change := payments.SubscriptionChange{
+		Kind:       payments.SubscriptionEnded,
+		UserID:     user.ID,
+		OccurredAt: time.Now(),
+	}
+	if params.IsChirpyRed {
+		change.Kind = payments.SubscriptionActivated
+	}
+
+	user, err = cfg.applySubscriptionChange(r.Context(), change)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminChirpyRed, auditTargetUser, user.ID.String())
+
+	respondWithJSON(w, 200, convertAdminUser(user))
+}
+
+// handlerAdminUsersRole promotes or demotes a user. A demotion takes effect
+// right away, a promotion once the user logs in again.
+func (cfg *apiConfig) handlerAdminUsersRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	user, ok := cfg.adminTargetUser(w, r, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if !auth.ValidRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "role must be user, moderator or admin", nil)
+		return
+	}
+
+	// Keeps the last admin from locking everyone out
+	if user.ID == claimsFromContext(r.Context()).UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role", nil)
+		return
+	}
+
+	err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		user, err = q.UpdateUserRole(r.Context(), database.UpdateUserRoleParams{
+			Role: params.Role,
+			ID:   user.ID,
+		})
+		if err != nil {
+			return err
+		}
+		return recordDomainEvent(r.Context(), q, eventUserRoleChanged, aggregateUser, user.ID.String(), convertAdminUser(user))
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminRoleChanged, auditTargetUser, user.ID.String())
+
+	respondWithJSON(w, 200, convertAdminUser(user))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookEventsDefaultLimit = 50
+	webhookEventsMaxLimit     = 500
+)
+
+type WebhookEvent struct {
+	ID             uuid.UUID       `json:"id"`
+	Provider       string          `json:"provider"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	ResponseStatus int32           `json:"response_status"`
+	Error          string          `json:"error,omitempty"`
+	Attempts       int32           `json:"attempts"`
+	ReceivedAt     time.Time       `json:"received_at"`
+	ProcessedAt    *time.Time      `json:"processed_at"`
+}
+
+func convertWebhookEvent(event database.WebhookEvent) WebhookEvent {
+	converted := WebhookEvent{
+		ID:             event.ID,
+		Provider:       event.Provider,
+		EventID:        event.EventID,
+		EventType:      event.EventType,
+		Payload:        json.RawMessage(event.Payload),
+		Status:         event.Status,
+		ResponseStatus: event.ResponseStatus,
+		Error:          event.Error,
+		Attempts:       event.Attempts,
+		ReceivedAt:     event.ReceivedAt,
+	}
+	if !json.Valid(converted.Payload) {
+		// Shouldn't happen, only decodable payloads are stored
+		converted.Payload, _ = json.Marshal(event.Payload)
+	}
+	if event.ProcessedAt.Valid {
+		converted.ProcessedAt = &event.ProcessedAt.Time
+	}
+	return converted
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEventsList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := webhookEventsDefaultLimit
+	if limitString := query.Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > webhookEventsMaxLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(webhookEventsMaxLimit), err)
+			return
+		}
+	}
+
+	dbEvents, err := cfg.dbQueries.ListWebhookEvents(r.Context(), database.ListWebhookEventsParams{
+		Provider: query.Get("provider"),
+		Status:   query.Get("status"),
+		MaxRows:  int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list webhook events", err)
+		return
+	}
+
+	events := []WebhookEvent{}
+	for _, event := range dbEvents {
+		events = append(events, convertWebhookEvent(event))
+	}
+
+	respondWithJSON(w, 200, events)
+}
+
+// handlerAdminWebhookEventsReplay runs a failed event again from its
+// stored payload, e.g. once the user it was about exists. Events stuck
+// processing past their lease can be replayed too.
+func (cfg *apiConfig) handlerAdminWebhookEventsReplay(w http.ResponseWriter, r *http.Request) {
+	eventID, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return
+	}
+
+	dbEvent, err := cfg.dbQueries.RetryWebhookEvent(r.Context(), database.RetryWebhookEventParams{
+		ID:          eventID,
+		StaleBefore: time.Now().Add(-webhookEventLease),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = cfg.dbQueries.GetWebhookEvent(r.Context(), eventID)
+		if err != nil {
+			respondWithError(w, 404, "Couldn't get webhook event", err)
+			return
+		}
+		respondWithError(w, http.StatusConflict, "Only failed or stuck events can be replayed", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't claim webhook event", err)
+		return
+	}
+
+	dbEvent = cfg.processWebhookEvent(r, dbEvent)
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminWebhookReplayed, auditTargetWebhookEvent, dbEvent.ID.String())
+
+	respondWithJSON(w, 200, convertWebhookEvent(dbEvent))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scope      string     `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func convertAPIKey(key database.ApiKey) APIKey {
+	converted := APIKey{
+		ID:        key.ID,
+		CreatedAt: key.CreatedAt,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scope:     key.Scope,
+	}
+	if key.ExpiresAt.Valid {
+		converted.ExpiresAt = &key.ExpiresAt.Time
+	}
+	if key.LastUsedAt.Valid {
+		converted.LastUsedAt = &key.LastUsedAt.Time
+	}
+	return converted
+}
+
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name             string `json:"name"`
+		Scope            string `json:"scope"`              // optional, space separated subset of the default scopes
+		ExpiresInSeconds *int   `json:"expires_in_seconds"` // optional, never expires by default
+	}
+
+	type response struct {
+		APIKey
+		Key string `json:"key"`
+	}
+
+	// Only a first-party login can mint keys: API keys can't create more
+	// of themselves and OAuth app tokens are refused
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name can't be empty", nil)
+		return
+	}
+
+	scopes, err := auth.ParseScopes(params.Scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scope", err)
+		return
+	}
+	if len(scopes) == 0 {
+		scopes = claims.Scopes()
+	}
+	// A key never outgrows the token that minted it
+	if !scopesAllowed(scopes, claims.Scopes()) {
+		respondWithError(w, 403, "Scope exceeds the token's scopes", nil)
+		return
+	}
+
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInSeconds != nil {
+		if *params.ExpiresInSeconds <= 0 {
+			respondWithError(w, http.StatusBadRequest, "expires_in_seconds must be positive", nil)
+			return
+		}
+		expiresAt = sql.NullTime{
+			Time:  time.Now().UTC().Add(time.Duration(*params.ExpiresInSeconds) * time.Second),
+			Valid: true,
+		}
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate API key", err)
+		return
+	}
+
+	dbKey, err := cfg.dbQueries.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:    claims.UserID,
+		Name:      params.Name,
+		Prefix:    prefix,
+		KeyHash:   auth.HashToken(key),
+		Scope:     strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+
+	// The key itself is only ever shown in this response
+	respondWithJSON(w, 201, response{
+		APIKey: convertAPIKey(dbKey),
+		Key:    key,
+	})
+}
+
+func (cfg *apiConfig) handlerAPIKeysGetAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersRead)
+	if !ok {
+		return
+	}
+
+	dbKeys, err := cfg.dbQueries.GetActiveAPIKeysForUser(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+
+	keys := []APIKey{}
+	for _, key := range dbKeys {
+		keys = append(keys, convertAPIKey(key))
+	}
+
+	respondWithJSON(w, 200, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeysDelete(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	// Scoped by user ID so nobody can revoke someone else's key
+	rows, err := cfg.dbQueries.RevokeAPIKeyForUser(r.Context(), database.RevokeAPIKeyForUserParams{
+		ID:     keyID,
+		UserID: claims.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, 404, "Couldn't find API key", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
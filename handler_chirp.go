@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 	"encoding/json"
+	"errors"
+	"database/sql"
 	"github.com/x6Nenko/Chirpy/internal/database"
 	"github.com/x6Nenko/Chirpy/internal/auth"
 	"github.com/google/uuid"
@@ -11,34 +13,45 @@ import (
 )
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	UserID    uuid.UUID `json:"user_id"`
-	Body      string    `json:"body"`
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Body      string     `json:"body"`
+	// ReplyTo is the chirp this one replies to, if it still exists
+	ReplyTo   *uuid.UUID `json:"reply_to,omitempty"`
+}
+
+func convertChirp(chirp database.Chirp) Chirp {
+	converted := Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		UserID:    chirp.UserID,
+		Body:      chirp.Body,
+	}
+	if chirp.ReplyTo.Valid {
+		converted.ReplyTo = &chirp.ReplyTo.UUID
+	}
+	return converted
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Body 	 string 	 `json:"body"`
 		// UserId uuid.UUID `json:"user_id"`
+		ReplyTo *uuid.UUID `json:"reply_to"`
 	}
 
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, 401, "Couldn't get bearer token", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized", err)
+	claims, ok := cfg.authenticateRequestOrAPIKey(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
+	userID := claims.UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
@@ -52,23 +65,40 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 
 	validatedChirp := replaceBadWords(params.Body)
 
-	chirp, err := cfg.dbQueries.CreateChirp(r.Context(), database.CreateChirpParams{
-    Body:   validatedChirp,
-    UserID: userID,
+	replyTo := uuid.NullUUID{}
+	if params.ReplyTo != nil {
+		_, err = cfg.dbQueries.GetOneChirp(r.Context(), *params.ReplyTo)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, "Couldn't get the chirp being replied to", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp being replied to", err)
+			return
+		}
+		replyTo = uuid.NullUUID{UUID: *params.ReplyTo, Valid: true}
+	}
+
+	// The chirp and its event are saved together
+	var convertedChirp Chirp
+	err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+		chirp, err := q.CreateChirp(r.Context(), database.CreateChirpParams{
+			Body:    validatedChirp,
+			UserID:  userID,
+			ReplyTo: replyTo,
+		})
+		if err != nil {
+			return err
+		}
+
+		convertedChirp = convertChirp(chirp)
+		return recordDomainEvent(r.Context(), q, eventChirpCreated, aggregateChirp, chirp.ID.String(), convertedChirp)
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
 		return
 	}
 
-	convertedChirp := Chirp{
-    ID:        chirp.ID,
-    CreatedAt: chirp.CreatedAt,
-    UpdatedAt: chirp.UpdatedAt,
-    Body:      chirp.Body,
-		UserID:		 userID,
-	}
-
 	respondWithJSON(w, 201, convertedChirp)
 }
 
@@ -104,13 +134,7 @@ func (cfg *apiConfig) handlerChirpsGetAll(w http.ResponseWriter, r *http.Request
 	// Convert database chirps to API chirps
 	convertedChirps := []Chirp{}
 	for _, chirp := range dbChirps {
-		convertedChirps = append(convertedChirps, Chirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		})
+		convertedChirps = append(convertedChirps, convertChirp(chirp))
 	}
 
 	// Apply sorting if requested
@@ -139,15 +163,7 @@ func (cfg *apiConfig) handlerChirpsGetOne(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	convertedChirp := Chirp{
-    ID:        chirp.ID,
-    CreatedAt: chirp.CreatedAt,
-    UpdatedAt: chirp.UpdatedAt,
-    Body:      chirp.Body,
-		UserID:		 chirp.UserID,
-	}
-
-	respondWithJSON(w, 200, convertedChirp)
+	respondWithJSON(w, 200, convertChirp(chirp))
 }
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
@@ -160,17 +176,11 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, 401, "Couldn't get bearer token", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized", err)
+	claims, ok := cfg.authenticateRequestOrAPIKey(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
+	userID := claims.UserID
 
 	chirp, err := cfg.dbQueries.GetOneChirp(r.Context(), chirpID)
 	if err != nil {
@@ -183,15 +193,23 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = cfg.dbQueries.DeleteOneChirp(r.Context(), database.DeleteOneChirpParams{
-		ID:    			chirpID,
-		UserID: 		userID,
+	err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+		err := q.DeleteOneChirp(r.Context(), database.DeleteOneChirpParams{
+			ID:    			chirpID,
+			UserID: 		userID,
+		})
+		if err != nil {
+			return err
+		}
+		return recordDomainEvent(r.Context(), q, eventChirpDeleted, aggregateChirp, chirpID.String(), convertChirp(chirp))
 	})
 	if err != nil {
 		respondWithError(w, 403, "Unauthorized", err)
 		return
 	}
 
+	cfg.recordAudit(r, auditActor(userID), auditChirpDeleted, auditTargetChirp, chirpID.String())
+
 	w.WriteHeader(204)
 	return
 }
\ No newline at end of file
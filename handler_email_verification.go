@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"encoding/json"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const emailVerificationTokenTTL = 24 * time.Hour
+
+// sendEmailVerification emails a single-use token to the address. Confirming
+// it marks the address as verified and, for an email change, makes it the
+// user's email.
+func (cfg *apiConfig) sendEmailVerification(userID uuid.UUID, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Only the newest link works, so an old change request can't win later
+	err := cfg.dbQueries.InvalidateEmailVerificationTokensForUser(ctx, userID)
+	if err != nil {
+		log.Printf("Error invalidating email verification tokens: %s", err)
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating email verification token: %s", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		UserID:    userID,
+		Email:     email,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(emailVerificationTokenTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving email verification token: %s", err)
+		return
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your email for Chirpy",
+		Body: fmt.Sprintf("Please confirm this is your email address with this token:\n\n%s\n\n"+
+			"Or open %s/app/verify-email?token=%s\n\n"+
+			"The token expires in 24 hours.\n", token, cfg.appURL, token),
+	})
+	if err != nil {
+		log.Printf("Error sending email verification: %s", err)
+	}
+}
+
+func (cfg *apiConfig) handlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	// Step 1: Decode the request body
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Step 2: Find the token, only its hash is stored
+	verificationToken, err := cfg.dbQueries.GetEmailVerificationTokenByHash(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+
+	// Step 3: Burn the token, the WHERE clause rejects used or expired ones
+	rows, err := cfg.dbQueries.UseEmailVerificationToken(r.Context(), verificationToken.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use verification token", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", nil)
+		return
+	}
+
+	// Step 4: Apply the address, someone may have taken it in the meantime
+	var user database.User
+	err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		user, err = q.UpdateUserEmail(r.Context(), database.UpdateUserEmailParams{
+			Email: verificationToken.Email,
+			ID:    verificationToken.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		return recordDomainEvent(r.Context(), q, eventUserUpdated, aggregateUser, user.ID.String(), convertAdminUser(user))
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Email is already in use", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+		return
+	}
+
+	convertedUser := User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		IsChirpyRed:   user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		TwoFactorEnabled: user.TotpEnabledAt.Valid,
+		Role:             user.Role,
+	}
+
+	respondWithJSON(w, 200, convertedUser)
+}
+
+func (cfg *apiConfig) handlerEmailVerifyResend(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateRequest(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return
+	}
+
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	cfg.queueEmail("verification", func() { cfg.sendEmailVerification(user.ID, user.Email) })
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -2,61 +2,204 @@ package main
 
 import (
 	"net/http"
-	"encoding/json"
 	"errors"
 	"database/sql"
-	"github.com/x6Nenko/Chirpy/internal/auth"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
 	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/payments"
 	"github.com/google/uuid"
 )
 
+const maxWebhookBodyBytes = 1 << 20
+
+const webhookProviderPolka = "polka"
+
+// Processing states of a webhook_events row
+const (
+	webhookEventProcessing = "processing"
+	webhookEventProcessed  = "processed"
+	webhookEventFailed     = "failed"
+)
+
+// webhookEventLease is how long an event may stay processing. Applying one
+// takes moments, so an older processing row was left by an instance that
+// crashed and can be claimed again.
+const webhookEventLease = 5 * time.Minute
+
+// handlerPolkaWebhooks is the URL Polka was first set up with
 func (cfg *apiConfig) handlerPolkaWebhooks(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-    Event string `json:"event"`
-    Data  struct {
-			UserID uuid.UUID `json:"user_id"`
-    } `json:"data"`
+	r.SetPathValue("provider", webhookProviderPolka)
+	cfg.handlerWebhooks(w, r)
+}
+
+// handlerWebhooks receives webhooks from the payment provider named by
+// {provider}. Each delivery is recorded in the webhook_events ledger before
+// acting on it. Providers retry until they get a 2xx, so a delivery seen
+// before gets the stored result back instead of being applied twice,
+// unless it failed on our side and is worth another go.
+func (cfg *apiConfig) handlerWebhooks(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	provider, ok := cfg.webhookProviders[providerName]
+	if !ok {
+		respondWithError(w, 404, "Unknown webhook provider", nil)
+		return
 	}
 
-	apiKey, err := auth.GetAPIKey(r.Header);
+	// Step 1: The signature covers the exact bytes sent, so read them before decoding
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get API key", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read body", err)
 		return
 	}
 
-	if apiKey != cfg.polkaKey {
-		w.WriteHeader(http.StatusUnauthorized)
+	err = provider.Authenticate(r, body)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature", err)
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	// Step 2: Identify the event. Without an ID from the provider every
+	// delivery is a new event: identical bodies can be separate events, like
+	// two upgrades with a downgrade in between.
+	event, err := provider.ParseEvent(r.Header, body)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
 
-	if params.Event != "user.upgraded" {
-		w.WriteHeader(http.StatusNoContent)
+	eventID := event.ID
+	if eventID == "" {
+		eventID = "unidentified:" + uuid.NewString()
+	}
+
+	// Step 3: Claim the event in the ledger
+	dbEvent, err := cfg.dbQueries.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		Provider:  providerName,
+		EventID:   eventID,
+		EventType: event.Type,
+		Payload:   string(body),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		dbEvent, err = cfg.claimDuplicateWebhookEvent(r.Context(), providerName, eventID)
+		if err != nil {
+			respondWithError(w, http.StatusConflict, "Event is already being processed", err)
+			return
+		}
+		if dbEvent.Status != webhookEventProcessing {
+			respondWithWebhookOutcome(w, dbEvent)
+			return
+		}
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record webhook event", err)
 		return
 	}
 
-	_, err = cfg.dbQueries.UpdateUserChirpyRed(r.Context(), database.UpdateUserChirpyRedParams{
-		IsChirpyRed:  true,
-		ID:						params.Data.UserID,
+	// Step 4: Apply it and remember the outcome
+	dbEvent = cfg.processWebhookEvent(r, dbEvent)
+	respondWithWebhookOutcome(w, dbEvent)
+}
+
+// claimDuplicateWebhookEvent looks up an event delivered before. Events
+// that failed with a server error, or whose processing lease ran out, are
+// claimed again for processing, others are returned as stored. It errors
+// while another delivery of the event is still being processed.
+func (cfg *apiConfig) claimDuplicateWebhookEvent(ctx context.Context, provider, eventID string) (database.WebhookEvent, error) {
+	dbEvent, err := cfg.dbQueries.GetWebhookEventByEventID(ctx, database.GetWebhookEventByEventIDParams{
+		Provider: provider,
+		EventID:  eventID,
 	})
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+
+	stale := dbEvent.Status == webhookEventProcessing && time.Since(dbEvent.UpdatedAt) > webhookEventLease
+	if dbEvent.Status == webhookEventProcessing && !stale {
+		return database.WebhookEvent{}, errors.New("webhook event " + eventID + " is being processed")
+	}
+	if stale || (dbEvent.Status == webhookEventFailed && dbEvent.ResponseStatus >= 500) {
+		// Fails if another delivery claimed it first
+		return cfg.dbQueries.RetryWebhookEvent(ctx, database.RetryWebhookEventParams{
+			ID:          dbEvent.ID,
+			StaleBefore: time.Now().Add(-webhookEventLease),
+		})
+	}
+	return dbEvent, nil
+}
+
+// processWebhookEvent applies a claimed event and stores how it went. The
+// outcome is stored even if the sender has hung up in the meantime.
+func (cfg *apiConfig) processWebhookEvent(r *http.Request, dbEvent database.WebhookEvent) database.WebhookEvent {
+	var status int
+	var err error
+	provider, ok := cfg.webhookProviders[dbEvent.Provider]
+	if ok {
+		status, err = cfg.applyWebhookEvent(r, provider, dbEvent)
+	} else {
+		status, err = http.StatusInternalServerError, errors.New("unknown webhook provider "+dbEvent.Provider)
+	}
+
+	params := database.FinishWebhookEventParams{
+		ID:             dbEvent.ID,
+		Status:         webhookEventProcessed,
+		ResponseStatus: int32(status),
+	}
+	if err != nil {
+		params.Status = webhookEventFailed
+		params.Error = err.Error()
+		log.Printf("Webhook event %s from %s failed: %s", dbEvent.EventID, dbEvent.Provider, err)
+	}
+
+	finished, err := cfg.dbQueries.FinishWebhookEvent(context.WithoutCancel(r.Context()), params)
+	if err != nil {
+		log.Printf("Couldn't store the outcome of webhook event %s: %s", dbEvent.EventID, err)
+		dbEvent.Status = params.Status
+		dbEvent.ResponseStatus = params.ResponseStatus
+		dbEvent.Error = params.Error
+		return dbEvent
+	}
+	return finished
+}
+
+// applyWebhookEvent acts on a stored event, returning the status to answer
+// with and, when it failed, why
+func (cfg *apiConfig) applyWebhookEvent(r *http.Request, provider payments.Provider, dbEvent database.WebhookEvent) (int, error) {
+	event, err := provider.ParseEvent(nil, []byte(dbEvent.Payload))
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("couldn't decode payload: %w", err)
+	}
+
+	change, ok := provider.SubscriptionChange(event)
+	if !ok {
+		return http.StatusNoContent, nil
+	}
+	if change.OccurredAt.IsZero() {
+		// The best guess at ordering events that carry no time of their own
+		change.OccurredAt = dbEvent.ReceivedAt
+	}
+
+	_, err = cfg.applySubscriptionChange(r.Context(), change)
 	if err != nil {
 		// Is this a "not found" error or a real problem?
     if errors.Is(err, sql.ErrNoRows) {
-			// This specific error means "nothing found"
-			w.WriteHeader(http.StatusNotFound)
-			return
+			return http.StatusNotFound, fmt.Errorf("user %s not found", change.UserID)
     }
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update user chirpy red status", err)
-		return
+		return http.StatusInternalServerError, fmt.Errorf("couldn't update subscription: %w", err)
 	}
 
-	w.WriteHeader(http.StatusNoContent)
-	return
-}
\ No newline at end of file
+	cfg.recordAudit(r, uuid.NullUUID{}, auditWebhookPrefix+event.Type, auditTargetUser, change.UserID.String())
+	return http.StatusNoContent, nil
+}
+
+// respondWithWebhookOutcome answers with the status stored for an event
+func respondWithWebhookOutcome(w http.ResponseWriter, dbEvent database.WebhookEvent) {
+	status := int(dbEvent.ResponseStatus)
+	if status >= 400 {
+		respondWithError(w, status, http.StatusText(status), nil)
+		return
+	}
+	w.WriteHeader(status)
+}
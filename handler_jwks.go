@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	// Downstream services may cache, but not for longer than a rotation
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.jwtKeys.JWKS())
+}
+
+// rotateSigningKeys swaps in a fresh signing key on every tick. Old keys
+// stay in the JWKS until the tokens they signed have expired.
+func (cfg *apiConfig) rotateSigningKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		key, err := cfg.jwtKeys.Rotate()
+		if err != nil {
+			log.Printf("Error rotating JWT signing key: %s", err)
+			continue
+		}
+		log.Printf("Rotated JWT signing key, new kid: %s", key.ID)
+	}
+}
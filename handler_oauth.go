@@ -0,0 +1,722 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthCodeTTL        = 10 * time.Minute
+	oauthAccessTokenTTL = time.Hour
+)
+
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scope        string    `json:"scope"`
+	Confidential bool      `json:"confidential"`
+}
+
+func convertOAuthClient(client database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           client.ID,
+		CreatedAt:    client.CreatedAt,
+		Name:         client.Name,
+		RedirectURIs: strings.Fields(client.RedirectUris),
+		Scope:        client.Scope,
+		Confidential: client.SecretHash.Valid,
+	}
+}
+
+// respondWithOAuthError writes an error in the RFC 6749 section 5.2 format
+// that OAuth client libraries expect, rather than our usual one
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	type errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, errorResponse{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
+
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scope        string   `json:"scope"`        // optional, the most the client may ever ask for
+		Confidential bool     `json:"confidential"` // server-side apps get a secret, the rest rely on PKCE alone
+	}
+
+	type response struct {
+		OAuthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Step 1: Validate the registration
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name can't be empty", nil)
+		return
+	}
+
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one redirect URI is required", nil)
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		// Stored space separated, so a space would split one URI into two
+		if strings.ContainsAny(uri, " \t\n") {
+			respondWithError(w, http.StatusBadRequest, "Redirect URIs can't contain spaces", nil)
+			return
+		}
+		err = auth.ValidateRedirectURI(uri)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI "+uri+": "+err.Error(), err)
+			return
+		}
+	}
+
+	scopes, err := auth.ParseOAuthScopes(params.Scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scope", err)
+		return
+	}
+	if len(scopes) == 0 {
+		scopes = auth.OAuthScopes
+	}
+
+	// Step 2: Generate credentials, only the secret's hash is kept
+	clientID, err := auth.MakeOAuthClientID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate client ID", err)
+		return
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate client secret", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.dbQueries.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		ID:           clientID,
+		OwnerID:      claims.UserID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: strings.Join(params.RedirectURIs, " "),
+		Scope:        strings.Join(scopes, " "),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save client", err)
+		return
+	}
+
+	// The secret is only ever shown in this response
+	respondWithJSON(w, 201, response{
+		OAuthClient:  convertOAuthClient(client),
+		ClientSecret: secret,
+	})
+}
+
+func (cfg *apiConfig) handlerOAuthClientsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersRead)
+	if !ok {
+		return
+	}
+
+	dbClients, err := cfg.dbQueries.GetOAuthClientsForOwner(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get clients", err)
+		return
+	}
+
+	clients := []OAuthClient{}
+	for _, client := range dbClients {
+		clients = append(clients, convertOAuthClient(client))
+	}
+
+	respondWithJSON(w, 200, clients)
+}
+
+func (cfg *apiConfig) handlerOAuthClientsDelete(w http.ResponseWriter, r *http.Request) {
+	clientID := r.PathValue("clientID")
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	// Its codes and refresh tokens go with it through ON DELETE CASCADE
+	rows, err := cfg.dbQueries.DeleteOAuthClientForOwner(r.Context(), database.DeleteOAuthClientForOwnerParams{
+		ID:      clientID,
+		OwnerID: claims.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete client", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, 404, "Couldn't find client", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type authorizationRequest struct {
+	client      database.OauthClient
+	redirectURI string // the one to send the user back to
+	rawRedirect string // as sent, the token request has to repeat it
+	scopes      []string
+	state       string
+	challenge   string
+}
+
+// parseAuthorizationRequest checks an authorization request (RFC 6749
+// 4.1.1). An error means the client or redirect_uri can't be trusted and
+// the user must not be sent back. Otherwise oauthErr, when set, is the
+// error code to send back to the client's redirect_uri.
+func (cfg *apiConfig) parseAuthorizationRequest(ctx context.Context, query url.Values) (req authorizationRequest, oauthErr string, err error) {
+	client, err := cfg.dbQueries.GetOAuthClient(ctx, query.Get("client_id"))
+	if err != nil {
+		return req, "", errors.New("unknown client_id")
+	}
+	req.client = client
+
+	// redirect_uri must match a registered one exactly, it may only be
+	// left out when there's just one
+	registered := strings.Fields(client.RedirectUris)
+	req.rawRedirect = query.Get("redirect_uri")
+	switch {
+	case req.rawRedirect == "" && len(registered) == 1:
+		req.redirectURI = registered[0]
+	case req.rawRedirect != "":
+		for _, uri := range registered {
+			if uri == req.rawRedirect {
+				req.redirectURI = uri
+			}
+		}
+	}
+	if req.redirectURI == "" {
+		return req, "", errors.New("redirect_uri doesn't match a registered one")
+	}
+
+	req.state = query.Get("state")
+
+	if query.Get("response_type") != "code" {
+		return req, "unsupported_response_type", nil
+	}
+
+	// PKCE is required for every client, confidential or not
+	req.challenge = query.Get("code_challenge")
+	if req.challenge == "" || query.Get("code_challenge_method") != auth.PKCEMethodS256 {
+		return req, "invalid_request", nil
+	}
+
+	// The client never gets more than it registered for, nor anything
+	// outside the OAuth scopes
+	if query.Get("scope") == "" {
+		req.scopes = auth.FilterOAuthScopes(strings.Fields(client.Scope))
+	} else {
+		req.scopes, err = auth.ParseOAuthScopes(query.Get("scope"))
+		if err != nil || !scopesAllowed(req.scopes, strings.Fields(client.Scope)) {
+			return req, "invalid_scope", nil
+		}
+	}
+	if len(req.scopes) == 0 {
+		return req, "invalid_scope", nil
+	}
+
+	return req, "", nil
+}
+
+// scopesAllowed reports whether every requested scope is in allowed
+func scopesAllowed(requested, allowed []string) bool {
+	for _, s := range requested {
+		if !scopesContain(allowed, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func scopesContain(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectWithParams adds params to the client's redirect URI, keeping any
+// query it already has
+func redirectWithParams(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// handlerOAuthAuthorize is where third-party apps send the user. Once the
+// request checks out the user is passed on to the consent page of the web
+// app, which talks to the consent endpoints below.
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	req, oauthErr, err := cfg.parseAuthorizationRequest(r.Context(), query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if oauthErr != "" {
+		http.Redirect(w, r, redirectWithParams(req.redirectURI, url.Values{
+			"error": {oauthErr},
+			"state": {req.state},
+		}), http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, cfg.appURL+"/app/authorize?"+query.Encode(), http.StatusFound)
+}
+
+// handlerOAuthConsentGet tells the consent page which app is asking for what
+func (cfg *apiConfig) handlerOAuthConsentGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ClientID    string   `json:"client_id"`
+		ClientName  string   `json:"client_name"`
+		RedirectURI string   `json:"redirect_uri"`
+		Scopes      []string `json:"scopes"`
+	}
+
+	_, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	req, oauthErr, err := cfg.parseAuthorizationRequest(r.Context(), r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if oauthErr != "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid authorization request: "+oauthErr, nil)
+		return
+	}
+
+	respondWithJSON(w, 200, response{
+		ClientID:    req.client.ID,
+		ClientName:  req.client.Name,
+		RedirectURI: req.redirectURI,
+		Scopes:      req.scopes,
+	})
+}
+
+// handlerOAuthConsent records the user's answer and tells the consent page
+// where to send the browser next
+func (cfg *apiConfig) handlerOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ResponseType        string `json:"response_type"`
+		ClientID            string `json:"client_id"`
+		RedirectURI         string `json:"redirect_uri"`
+		Scope               string `json:"scope"`
+		State               string `json:"state"`
+		CodeChallenge       string `json:"code_challenge"`
+		CodeChallengeMethod string `json:"code_challenge_method"`
+		Approve             bool   `json:"approve"`
+	}
+
+	type response struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Step 1: Check the request again, the page could have been tampered with
+	req, oauthErr, err := cfg.parseAuthorizationRequest(r.Context(), url.Values{
+		"response_type":         {params.ResponseType},
+		"client_id":             {params.ClientID},
+		"redirect_uri":          {params.RedirectURI},
+		"scope":                 {params.Scope},
+		"state":                 {params.State},
+		"code_challenge":        {params.CodeChallenge},
+		"code_challenge_method": {params.CodeChallengeMethod},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if oauthErr == "" && !params.Approve {
+		oauthErr = "access_denied"
+	}
+	if oauthErr != "" {
+		respondWithJSON(w, 200, response{
+			RedirectTo: redirectWithParams(req.redirectURI, url.Values{
+				"error": {oauthErr},
+				"state": {req.state},
+			}),
+		})
+		return
+	}
+
+	// Step 2: Issue a single-use code, only its hash is stored
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate authorization code", err)
+		return
+	}
+
+	err = cfg.dbQueries.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      req.client.ID,
+		UserID:        claims.UserID,
+		RedirectUri:   req.rawRedirect,
+		Scope:         strings.Join(req.scopes, " "),
+		CodeChallenge: req.challenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save authorization code", err)
+		return
+	}
+
+	respondWithJSON(w, 200, response{
+		RedirectTo: redirectWithParams(req.redirectURI, url.Values{
+			"code":  {code},
+			"state": {req.state},
+		}),
+	})
+}
+
+// authenticateOAuthClient reads client credentials from HTTP Basic auth or
+// the form body. Public clients only send their client_id. On failure it
+// has already written the response.
+func (cfg *apiConfig) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (database.OauthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if !basic {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err == nil {
+		if !client.SecretHash.Valid && secret == "" {
+			return client, true
+		}
+		if client.SecretHash.Valid && subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) == 1 {
+			return client, true
+		}
+	}
+
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithOAuthError(w, 401, "invalid_client", "Client authentication failed")
+	return database.OauthClient{}, false
+}
+
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	// Step 1: Token requests are form encoded (RFC 6749 4.1.3)
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Couldn't parse form")
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	// Step 2: Work out who the token is for and with which scopes
+	var userID uuid.UUID
+	var refreshToken string
+	var sessionID uuid.UUID
+	var scopes []string
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := cfg.dbQueries.UseOAuthAuthorizationCode(r.Context(), auth.HashToken(r.PostForm.Get("code")))
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code")
+			return
+		}
+
+		// The code is burned either way, a failed attempt can't be retried
+		if code.ClientID != client.ID || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client or redirect_uri")
+			return
+		}
+		if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match the code_challenge")
+			return
+		}
+
+		userID = code.UserID
+		scopes = strings.Fields(code.Scope)
+
+	case "refresh_token":
+		refreshToken = r.PostForm.Get("refresh_token")
+		dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), refreshToken)
+		if err != nil || dbRefreshToken.ClientID.String != client.ID ||
+			dbRefreshToken.RevokedAt.Valid || time.Now().After(dbRefreshToken.ExpiresAt) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired refresh token")
+			return
+		}
+
+		// A refresh may narrow the scopes, never widen them
+		scopes = strings.Fields(dbRefreshToken.Scope)
+		if r.PostForm.Get("scope") != "" {
+			requested := strings.Fields(r.PostForm.Get("scope"))
+			if !scopesAllowed(requested, scopes) {
+				respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope", "Scope exceeds the original grant")
+				return
+			}
+			scopes = requested
+		}
+
+		err = cfg.dbQueries.TouchRefreshToken(r.Context(), database.TouchRefreshTokenParams{
+			Token:     refreshToken,
+			IpAddress: getClientIP(r),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update session", err)
+			return
+		}
+		userID = dbRefreshToken.UserID
+		sessionID = dbRefreshToken.ID
+
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	// Grants stored before the OAuth scopes were split off lose the rest
+	scopes = auth.FilterOAuthScopes(scopes)
+	if len(scopes) == 0 {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope", "No scope can be granted to an app")
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil || user.SuspendedAt.Valid {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Account is unavailable")
+		return
+	}
+
+	// A new grant starts a session, a refresh keeps using its own
+	if refreshToken == "" {
+		dbRefreshToken, err := cfg.createRefreshToken(r, userID, scopes, sql.NullString{String: client.ID, Valid: true})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save Refresh token", err)
+			return
+		}
+		refreshToken = dbRefreshToken.Token
+		sessionID = dbRefreshToken.ID
+	}
+
+	// Step 3: The same access tokens a password login gets, except that
+	// an app never acts with more than the user role and the client_id
+	// keeps it out of account management
+	accessToken, err := auth.MakeJWT(auth.AccessToken{
+		UserID:    userID,
+		Role:      auth.RoleUser,
+		Scopes:    scopes,
+		SessionID: sessionID,
+		ClientID:  client.ID,
+	}, cfg.jwtKeys, oauthAccessTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate JWT token", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, 200, response{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// handlerOAuthIntrospect implements RFC 7662 for confidential clients.
+// Access tokens can be checked by any of them (they act as resource
+// servers), refresh tokens only by the client they were issued to.
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+		JTI       string `json:"jti,omitempty"`
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Couldn't parse form")
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+	if !client.SecretHash.Valid {
+		respondWithOAuthError(w, 401, "invalid_client", "Only confidential clients may introspect tokens")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+
+	claims, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err == nil {
+		revoked, err := cfg.denylist.IsRevoked(r.Context(), claims)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check token revocation", err)
+			return
+		}
+		if revoked {
+			respondWithJSON(w, 200, response{Active: false})
+			return
+		}
+
+		respondWithJSON(w, 200, response{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			TokenType: "access_token",
+			Subject:   claims.Subject,
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+			JTI:       claims.ID,
+		})
+		return
+	}
+
+	dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), token)
+	if err != nil || dbRefreshToken.ClientID.String != client.ID ||
+		dbRefreshToken.RevokedAt.Valid || time.Now().After(dbRefreshToken.ExpiresAt) {
+		respondWithJSON(w, 200, response{Active: false})
+		return
+	}
+
+	respondWithJSON(w, 200, response{
+		Active:    true,
+		Scope:     dbRefreshToken.Scope,
+		ClientID:  client.ID,
+		TokenType: "refresh_token",
+		Subject:   dbRefreshToken.UserID.String(),
+		ExpiresAt: dbRefreshToken.ExpiresAt.Unix(),
+		IssuedAt:  dbRefreshToken.CreatedAt.Unix(),
+	})
+}
+
+// handlerOAuthRevoke implements RFC 7009. Unknown tokens, first-party
+// tokens and tokens of other clients are ignored, the response is 200
+// either way.
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Couldn't parse form")
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostForm.Get("token")
+
+	claims, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err == nil {
+		if claims.ClientID == client.ID {
+			err = cfg.denylist.Revoke(r.Context(), claims)
+			if err != nil {
+				respondWithError(w, http.StatusServiceUnavailable, "Couldn't revoke access token", err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), token)
+	if err == nil && dbRefreshToken.ClientID.String == client.ID {
+		err = cfg.dbQueries.RevokeRefreshToken(r.Context(), token)
+		if err != nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Couldn't revoke refresh token", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"encoding/json"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/mailer"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Each address and IP only gets a few emails, whether or not the
+	// address has an account
+	if !cfg.checkPasswordResetThrottle(w, r, params.Email) {
+		return
+	}
+
+	// The lookup and email happen in the background and the response is the
+	// same either way, so neither the body nor the timing tells the caller
+	// whether the email has an account.
+	cfg.queueEmail("password reset", func() { cfg.sendPasswordResetEmail(params.Email) })
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := cfg.dbQueries.GetUserByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+
+	// Only the newest link works
+	err = cfg.dbQueries.InvalidatePasswordResetTokensForUser(ctx, user.ID)
+	if err != nil {
+		log.Printf("Error invalidating password reset tokens: %s", err)
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating password reset token: %s", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTokenTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving password reset token: %s", err)
+		return
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"Use this token within the next hour to choose a new password:\n\n%s\n\n"+
+			"Or open %s/app/reset-password?token=%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", token, cfg.appURL, token),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email: %s", err)
+	}
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	// Step 1: Decode the request body
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Step 2: Find the token, only its hash is stored
+	resetToken, err := cfg.dbQueries.GetPasswordResetTokenByHash(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+
+	// Check the password before burning the token so the user can retry
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+
+	err = cfg.passwordPolicy.Validate(params.Password, user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// Step 3: Burn the token, the WHERE clause rejects used or expired ones
+	rows, err := cfg.dbQueries.UsePasswordResetToken(r.Context(), resetToken.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use reset token", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", nil)
+		return
+	}
+
+	// Step 4: Hash and save the new password
+	hashedPass, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPass,
+		ID:             resetToken.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	// Step 5: Whoever had the old password shouldn't stay logged in
+	err = cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
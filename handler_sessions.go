@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	ClientID   string    `json:"client_id,omitempty"` // set for sessions of OAuth apps
+}
+
+func (cfg *apiConfig) handlerSessionsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersRead)
+	if !ok {
+		return
+	}
+	userID := claims.UserID
+
+	dbSessions, err := cfg.dbQueries.GetActiveSessionsForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+
+	// Never expose the refresh token itself, only the session ID
+	sessions := []Session{}
+	for _, session := range dbSessions {
+		sessions = append(sessions, Session{
+			ID:         session.ID,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IpAddress,
+			ClientID:   session.ClientID.String,
+		})
+	}
+
+	respondWithJSON(w, 200, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	sessionIDString := r.PathValue("sessionID") // String literal matches {sessionID} from route
+
+	sessionID, err := uuid.Parse(sessionIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+	userID := claims.UserID
+
+	// Scoped by user ID so nobody can revoke someone else's session
+	rows, err := cfg.dbQueries.RevokeSessionForUser(r.Context(), database.RevokeSessionForUserParams{
+		ID:     sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, 404, "Couldn't find session", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+	userID := claims.UserID
+
+	err := cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/events"
+	"github.com/google/uuid"
+)
+
+const (
+	streamHeartbeatInterval = 15 * time.Second
+	// streamClientBuffer is how many events a client can fall behind before
+	// it's disconnected to resume from where it got to
+	streamClientBuffer = 64
+	// streamRetry is how long browsers wait before reconnecting, in ms
+	streamRetry = 3000
+)
+
+// handlerStream pushes chirp.created and chirp.deleted events as
+// Server-Sent Events, optionally only those by ?author_id= or tagged with
+// ?hashtag=. A client reconnecting with Last-Event-ID first gets the events
+// it missed, as long as they're still in the replay buffer.
+func (cfg *apiConfig) handlerStream(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Parse the filters
+	filter := chirpFilter{
+		hashtag: strings.ToLower(strings.TrimPrefix(r.URL.Query().Get("hashtag"), "#")),
+	}
+	if authorIDString := r.URL.Query().Get("author_id"); authorIDString != "" {
+		authorID, err := uuid.Parse(authorIDString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author_id", err)
+			return
+		}
+		filter.authorID = authorID
+	}
+
+	// EventSource sends Last-Event-ID when it reconnects, the query
+	// parameter is for resuming a fresh one
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	// Step 2: Subscribe before anything is written, so no event falls
+	// between the replay and the live ones
+	sub, replay, found := cfg.streamHub.Subscribe(lastEventID, streamClientBuffer)
+	defer cfg.streamHub.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	if lastEventID != "" && !found {
+		// Too far behind to catch up, the client should reload instead
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+
+	// Step 3: Catch up, then follow along
+	for _, event := range replay {
+		writeStreamEvent(w, filter, event)
+	}
+	err := rc.Flush()
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Fell behind, the client reconnects with Last-Event-ID
+				return
+			}
+			if !writeStreamEvent(w, filter, event) {
+				continue
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+
+		err := rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeStreamEvent writes event if it passes the filter, reporting whether
+// it did
+func writeStreamEvent(w http.ResponseWriter, filter chirpFilter, event events.Event) bool {
+	if !filter.matches(event) {
+		return false
+	}
+	// Data is compact JSON, so it fits on one data: line
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return true
+}
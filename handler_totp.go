@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"encoding/json"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+)
+
+const (
+	totpIssuer         = "Chirpy"
+	mfaTokenTTL        = 5 * time.Minute
+	recoveryCodesCount = 10
+)
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code. Both are single-use: a TOTP step can't be replayed and a
+// recovery code is burned on success.
+func (cfg *apiConfig) verifySecondFactor(ctx context.Context, user database.User, code string) (bool, error) {
+	if user.TotpSecret.Valid {
+		step, ok := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+		if ok {
+			rows, err := cfg.dbQueries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+				TotpLastStep: step,
+				ID:           user.ID,
+			})
+			if err != nil {
+				return false, err
+			}
+			return rows == 1, nil
+		}
+	}
+
+	rows, err := cfg.dbQueries.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+		UserID:   user.ID,
+		CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+	})
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret        string   `json:"secret"`
+		OTPAuthURI    string   `json:"otpauth_uri"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	// Step 1: New secret, not active until the user confirms a code
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate TOTP secret", err)
+		return
+	}
+
+	err = cfg.dbQueries.SetUserTOTPSecret(r.Context(), database.SetUserTOTPSecretParams{
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+		ID:         user.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	// Step 2: Fresh recovery codes, only their hashes are kept
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+
+	err = cfg.dbQueries.DeleteRecoveryCodesForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't replace recovery codes", err)
+		return
+	}
+
+	for _, code := range recoveryCodes {
+		err = cfg.dbQueries.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashToken(code),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, 200, response{
+		Secret:        secret,
+		OTPAuthURI:    auth.TOTPURI(totpIssuer, user.Email, secret),
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Start enrolment first", nil)
+		return
+	}
+
+	// Proves the authenticator app was set up correctly, recovery codes
+	// don't count here
+	step, ok := auth.ValidateTOTP(user.TotpSecret.String, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", nil)
+		return
+	}
+
+	_, err = cfg.dbQueries.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+		TotpLastStep: step,
+		ID:           user.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP step", err)
+		return
+	}
+
+	err = cfg.dbQueries.EnableUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return
+	}
+
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication is not enabled", nil)
+		return
+	}
+
+	// A stolen access token alone must not be enough to turn 2FA off
+	ok, err = cfg.verifySecondFactor(r.Context(), user, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, 401, "Invalid code", nil)
+		return
+	}
+
+	err = cfg.dbQueries.DisableUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	err = cfg.dbQueries.DeleteRecoveryCodesForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete recovery codes", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"` // TOTP code or recovery code
+	}
+
+	// Step 1: Decode the request body
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Step 2: The MFA token proves the password step already passed
+	claims, err := auth.ValidateMFAToken(params.MFAToken, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired MFA token", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil || !user.TotpEnabledAt.Valid {
+		respondWithError(w, 401, "Invalid or expired MFA token", err)
+		return
+	}
+
+	if user.SuspendedAt.Valid {
+		respondWithError(w, 403, "Account is suspended", nil)
+		return
+	}
+
+	// Step 3: Check the second factor, guesses count like wrong passwords
+	if !cfg.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), user, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, 401, "Invalid code", nil)
+		return
+	}
+
+	// Step 4: Logged in, with the scopes asked for at the password step
+	cfg.releaseLoginAttempt(r.Context(), user.Email, getClientIP(r))
+	cfg.clearLoginFailures(r.Context(), user.Email)
+	cfg.cancelAccountDeletion(r.Context(), user)
+	cfg.recordAudit(r, auditActor(user.ID), auditLoginSucceeded, auditTargetUser, user.ID.String())
+	cfg.respondWithNewSession(w, r, user, claims.Scopes())
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookDeliveriesDefaultLimit = 50
+	webhookDeliveriesMaxLimit     = 500
+)
+
+type WebhookSubscription struct {
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	URL          string     `json:"url"`
+	Events       []string   `json:"events"`
+	FailureCount int32      `json:"failure_count"`
+	DisabledAt   *time.Time `json:"disabled_at"`
+}
+
+func convertWebhookSubscription(subscription database.WebhookSubscription) WebhookSubscription {
+	converted := WebhookSubscription{
+		ID:           subscription.ID,
+		CreatedAt:    subscription.CreatedAt,
+		URL:          subscription.Url,
+		Events:       strings.Fields(subscription.Events),
+		FailureCount: subscription.FailureCount,
+	}
+	if subscription.DisabledAt.Valid {
+		converted.DisabledAt = &subscription.DisabledAt.Time
+	}
+	return converted
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	ResponseStatus int32           `json:"response_status"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+func convertWebhookDelivery(delivery database.WebhookDelivery) WebhookDelivery {
+	converted := WebhookDelivery{
+		ID:             delivery.ID,
+		CreatedAt:      delivery.CreatedAt,
+		EventType:      delivery.EventType,
+		Payload:        json.RawMessage(delivery.Payload),
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+	}
+	if delivery.Status == webhookDeliveryPending {
+		converted.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.LastAttemptAt.Valid {
+		converted.LastAttemptAt = &delivery.LastAttemptAt.Time
+	}
+	if delivery.DeliveredAt.Valid {
+		converted.DeliveredAt = &delivery.DeliveredAt.Time
+	}
+	return converted
+}
+
+func (cfg *apiConfig) handlerWebhookSubscriptionsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	type response struct {
+		WebhookSubscription
+		Secret string `json:"secret"`
+	}
+
+	claims, ok := cfg.authenticateRequest(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err = webhooks.ValidateURL(params.URL, cfg.platform == "dev")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Subscribe to at least one event", nil)
+		return
+	}
+	for _, event := range params.Events {
+		if !webhooks.ValidEvent(event) {
+			respondWithError(w, http.StatusBadRequest, "Unknown event "+strconv.Quote(event), nil)
+			return
+		}
+	}
+
+	secret, err := webhooks.MakeSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+
+	subscription, err := cfg.dbQueries.CreateWebhookSubscription(r.Context(), database.CreateWebhookSubscriptionParams{
+		UserID: claims.UserID,
+		Url:    params.URL,
+		Events: strings.Join(params.Events, " "),
+		Secret: secret,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save webhook subscription", err)
+		return
+	}
+
+	// The secret is only ever shown in this response
+	respondWithJSON(w, 201, response{
+		WebhookSubscription: convertWebhookSubscription(subscription),
+		Secret:              secret,
+	})
+}
+
+func (cfg *apiConfig) handlerWebhookSubscriptionsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateRequest(w, r, auth.ScopeUsersRead)
+	if !ok {
+		return
+	}
+
+	dbSubscriptions, err := cfg.dbQueries.GetWebhookSubscriptionsForUser(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook subscriptions", err)
+		return
+	}
+
+	subscriptions := []WebhookSubscription{}
+	for _, subscription := range dbSubscriptions {
+		subscriptions = append(subscriptions, convertWebhookSubscription(subscription))
+	}
+
+	respondWithJSON(w, 200, subscriptions)
+}
+
+func (cfg *apiConfig) handlerWebhookSubscriptionsDelete(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := uuid.Parse(r.PathValue("subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return
+	}
+
+	claims, ok := cfg.authenticateRequest(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	// Scoped by user ID so nobody can delete someone else's subscription
+	rows, err := cfg.dbQueries.DeleteWebhookSubscriptionForUser(r.Context(), database.DeleteWebhookSubscriptionForUserParams{
+		ID:     subscriptionID,
+		UserID: claims.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook subscription", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, 404, "Couldn't find webhook subscription", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWebhookSubscriptionsEnable turns a subscription back on after it
+// was disabled for failing, deliveries given up on aren't resent
+func (cfg *apiConfig) handlerWebhookSubscriptionsEnable(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := uuid.Parse(r.PathValue("subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return
+	}
+
+	claims, ok := cfg.authenticateRequest(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	subscription, err := cfg.dbQueries.EnableWebhookSubscriptionForUser(r.Context(), database.EnableWebhookSubscriptionForUserParams{
+		ID:     subscriptionID,
+		UserID: claims.UserID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Couldn't find webhook subscription", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable webhook subscription", err)
+		return
+	}
+
+	respondWithJSON(w, 200, convertWebhookSubscription(subscription))
+}
+
+// handlerWebhookDeliveriesGetAll is the delivery log of a subscription,
+// newest first
+func (cfg *apiConfig) handlerWebhookDeliveriesGetAll(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := uuid.Parse(r.PathValue("subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse UUID string", err)
+		return
+	}
+
+	claims, ok := cfg.authenticateRequest(w, r, auth.ScopeUsersRead)
+	if !ok {
+		return
+	}
+
+	limit := webhookDeliveriesDefaultLimit
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > webhookDeliveriesMaxLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(webhookDeliveriesMaxLimit), err)
+			return
+		}
+	}
+
+	_, err = cfg.dbQueries.GetWebhookSubscriptionForUser(r.Context(), database.GetWebhookSubscriptionForUserParams{
+		ID:     subscriptionID,
+		UserID: claims.UserID,
+	})
+	if err != nil {
+		respondWithError(w, 404, "Couldn't find webhook subscription", err)
+		return
+	}
+
+	dbDeliveries, err := cfg.dbQueries.GetWebhookDeliveriesForSubscription(r.Context(), database.GetWebhookDeliveriesForSubscriptionParams{
+		SubscriptionID: subscriptionID,
+		Limit:          int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook deliveries", err)
+		return
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range dbDeliveries {
+		deliveries = append(deliveries, convertWebhookDelivery(delivery))
+	}
+
+	respondWithJSON(w, 200, deliveries)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/events"
+	"github.com/x6Nenko/Chirpy/internal/websocket"
+	"github.com/google/uuid"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	// wsIdleTimeout drops clients that stopped answering pings
+	wsIdleTimeout = 2 * wsPingInterval
+	// wsSendBuffer is how many events a connection can fall behind before
+	// it's closed with 1013 and the client should reconnect
+	wsSendBuffer = 64
+	// wsExpiryWarning is how long before the token expires the client is
+	// asked for a new one
+	wsExpiryWarning = time.Minute
+	// wsCloseWait is how long a close we started waits for the client to
+	// answer it
+	wsCloseWait        = 5 * time.Second
+	wsMaxSubscriptions = 50
+	wsMaxMessageSize   = 4 << 10
+)
+
+// wsCloseTokenExpired closes connections whose access token ran out or
+// was revoked
+const wsCloseTokenExpired = 4001
+
+// Channels clients can subscribe to. The channel for a chirp's replies is
+// wsChannelChirpPrefix followed by its ID.
+const (
+	wsChannelTimeline    = "timeline"
+	wsChannelMentions    = "mentions"
+	wsChannelChirpPrefix = "chirp:"
+)
+
+// wsClientMessage is anything a client sends
+type wsClientMessage struct {
+	Type    string `json:"type"` // subscribe, unsubscribe, auth or ping
+	Channel string `json:"channel,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// wsServerMessage is anything sent to a client
+type wsServerMessage struct {
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel,omitempty"`
+	Event     string          `json:"event,omitempty"`
+	ID        *uuid.UUID      `json:"id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// wsSession is the state of one connection
+type wsSession struct {
+	conn          *websocket.Conn
+	userID        uuid.UUID
+	email         string
+	claims        *auth.Claims // of the latest token, checked for revocation
+	expiresAt     time.Time
+	subscriptions map[string]bool
+}
+
+// handlerWebSocket upgrades to a WebSocket that pushes chirp events for the
+// channels the client subscribes to. It's authenticated like any other
+// request; browsers, which can't set headers on a WebSocket, may pass the
+// token as ?access_token=. Before the token expires the client is asked
+// for a new one, and the socket is closed with 4001 if it doesn't send it.
+// Every ping also checks the token hasn't been revoked (closing with 4001)
+// and the account hasn't been suspended or deleted (closing with 1008).
+func (cfg *apiConfig) handlerWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Authenticate before upgrading, so failures are plain HTTP errors
+	if r.Header.Get("Authorization") == "" && r.URL.Query().Has("access_token") {
+		r.Header.Set("Authorization", "Bearer "+r.URL.Query().Get("access_token"))
+	}
+	claims, ok := cfg.authenticateRequest(w, r, "")
+	if !ok {
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized", err)
+		return
+	}
+	if user.SuspendedAt.Valid {
+		respondWithError(w, 403, "Account is suspended", nil)
+		return
+	}
+
+	// Step 2: Upgrade. Subscribing to the hub first means no event is missed.
+	sub, _, _ := cfg.streamHub.Subscribe("", wsSendBuffer)
+	defer cfg.streamHub.Unsubscribe(sub)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.MaxMessageSize = wsMaxMessageSize
+	conn.IdleTimeout = wsIdleTimeout
+
+	session := &wsSession{
+		conn:          conn,
+		userID:        user.ID,
+		email:         user.Email,
+		claims:        claims,
+		expiresAt:     tokenExpiry(claims),
+		subscriptions: map[string]bool{},
+	}
+
+	// Step 3: Read in the background, everything else happens here
+	incoming := make(chan wsClientMessage)
+	readDone := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var message wsClientMessage
+			err = json.Unmarshal(data, &message)
+			if err != nil {
+				session.send(wsServerMessage{Type: "error", Error: "Couldn't decode message"})
+				continue
+			}
+			select {
+			case incoming <- message:
+			case <-done:
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	expiry := time.NewTimer(time.Until(session.expiresAt.Add(-wsExpiryWarning)))
+	defer expiry.Stop()
+	warned := false
+
+	closeCode, closeReason := 0, ""
+loop:
+	for {
+		var err error
+		select {
+		case <-readDone:
+			break loop
+		case message := <-incoming:
+			expiresAt := session.expiresAt
+			err = cfg.handleWebSocketMessage(r, session, message)
+			if session.expiresAt != expiresAt {
+				warned = false
+				expiry.Reset(time.Until(session.expiresAt.Add(-wsExpiryWarning)))
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				closeCode, closeReason = websocket.CloseTryAgainLater, "too slow, reconnect"
+				break loop
+			}
+			err = session.sendEvent(event)
+		case <-ping.C:
+			closeCode, closeReason = cfg.checkWebSocketSession(r.Context(), session)
+			if closeCode != 0 {
+				break loop
+			}
+			err = conn.WritePing(time.Now().Add(wsWriteTimeout))
+		case <-expiry.C:
+			if warned {
+				closeCode, closeReason = wsCloseTokenExpired, "token expired"
+				break loop
+			}
+			warned = true
+			expiry.Reset(time.Until(session.expiresAt))
+			err = session.send(wsServerMessage{Type: "token_expiring", ExpiresAt: &session.expiresAt})
+		}
+		if err != nil {
+			break loop
+		}
+	}
+
+	// Step 4: Close cleanly, letting the client answer the close
+	close(done)
+	if closeCode != 0 && conn.WriteClose(closeCode, closeReason) == nil {
+		select {
+		case <-readDone:
+		case <-time.After(wsCloseWait):
+		}
+	}
+}
+
+// handleWebSocketMessage acts on a client message. Mistakes are answered
+// with an error message; only failing to write ends the connection.
+func (cfg *apiConfig) handleWebSocketMessage(r *http.Request, session *wsSession, message wsClientMessage) error {
+	switch message.Type {
+	case "subscribe":
+		if !validWebSocketChannel(message.Channel) {
+			return session.send(wsServerMessage{Type: "error", Channel: message.Channel, Error: "Unknown channel"})
+		}
+		if !session.subscriptions[message.Channel] && len(session.subscriptions) >= wsMaxSubscriptions {
+			return session.send(wsServerMessage{Type: "error", Channel: message.Channel, Error: "Too many subscriptions"})
+		}
+		session.subscriptions[message.Channel] = true
+		return session.send(wsServerMessage{Type: "subscribed", Channel: message.Channel})
+	case "unsubscribe":
+		delete(session.subscriptions, message.Channel)
+		return session.send(wsServerMessage{Type: "unsubscribed", Channel: message.Channel})
+	case "auth":
+		claims, err := cfg.renewWebSocketToken(r, session, message.Token)
+		if err != nil {
+			// The old token still holds until it expires
+			session.send(wsServerMessage{Type: "error", Error: "Invalid token"})
+			return nil
+		}
+		expiresAt := tokenExpiry(claims)
+		session.claims = claims
+		session.expiresAt = expiresAt
+		return session.send(wsServerMessage{Type: "authenticated", ExpiresAt: &expiresAt})
+	case "ping":
+		return session.send(wsServerMessage{Type: "pong"})
+	default:
+		return session.send(wsServerMessage{Type: "error", Error: "Unknown message type"})
+	}
+}
+
+// renewWebSocketToken checks a new access token for the same user
+func (cfg *apiConfig) renewWebSocketToken(r *http.Request, session *wsSession, tokenString string) (*auth.Claims, error) {
+	claims, err := auth.ValidateJWT(tokenString, cfg.jwtKeys)
+	if err != nil {
+		return nil, err
+	}
+	if claims.UserID != session.userID {
+		return nil, errors.New("token is for another user")
+	}
+	revoked, err := cfg.denylist.IsRevoked(r.Context(), claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+	return claims, nil
+}
+
+// checkWebSocketSession returns the close code and reason for a session
+// whose token was revoked or whose account was suspended or deleted since
+// it connected, or 0 if it may carry on. A failed check is logged and
+// tried again on the next ping rather than dropping the client.
+func (cfg *apiConfig) checkWebSocketSession(ctx context.Context, session *wsSession) (int, string) {
+	revoked, err := cfg.denylist.IsRevoked(ctx, session.claims)
+	if err != nil {
+		log.Printf("Couldn't check websocket token revocation: %s", err)
+		return 0, ""
+	}
+	if revoked {
+		return wsCloseTokenExpired, "token revoked"
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(ctx, session.userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return websocket.ClosePolicyViolation, "account deleted"
+	}
+	if err != nil {
+		log.Printf("Couldn't check websocket user %s: %s", session.userID, err)
+		return 0, ""
+	}
+	if user.SuspendedAt.Valid {
+		return websocket.ClosePolicyViolation, "account suspended"
+	}
+	return 0, ""
+}
+
+func tokenExpiry(claims *auth.Claims) time.Time {
+	if claims.ExpiresAt == nil {
+		// Access tokens always expire, but don't keep a socket open forever
+		return time.Now().Add(time.Hour)
+	}
+	return claims.ExpiresAt.Time
+}
+
+func validWebSocketChannel(channel string) bool {
+	switch channel {
+	case wsChannelTimeline, wsChannelMentions:
+		return true
+	}
+	chirpID, found := strings.CutPrefix(channel, wsChannelChirpPrefix)
+	if !found {
+		return false
+	}
+	_, err := uuid.Parse(chirpID)
+	return err == nil
+}
+
+// sendEvent passes event on for every subscribed channel it belongs to
+func (s *wsSession) sendEvent(event events.Event) error {
+	var chirp Chirp
+	err := json.Unmarshal(event.Data, &chirp)
+	if err != nil {
+		log.Printf("Couldn't decode %s event %s: %s", event.Type, event.ID, err)
+		return nil
+	}
+
+	channels := []string{wsChannelTimeline}
+	if chirp.ReplyTo != nil {
+		channels = append(channels, wsChannelChirpPrefix+chirp.ReplyTo.String())
+	}
+	if event.Type == eventChirpCreated && chirp.UserID != s.userID && chirpMentions(chirp.Body, s.email) {
+		channels = append(channels, wsChannelMentions)
+	}
+
+	for _, channel := range channels {
+		if !s.subscriptions[channel] {
+			continue
+		}
+		err := s.send(wsServerMessage{
+			Type:    "event",
+			Channel: channel,
+			Event:   event.Type,
+			ID:      &event.ID,
+			Data:    event.Data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *wsSession) send(message wsServerMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, data, time.Now().Add(wsWriteTimeout))
+}
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"log"
 	"net/http"
 	"encoding/json"
 	"time"
+	"strings"
+	"errors"
+	"io"
 	"github.com/x6Nenko/Chirpy/internal/auth"
 	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/mailer"
+	"github.com/google/uuid"
 )
 
+// accessTokenTTL is how long a first-party access token lasts
+const accessTokenTTL = time.Hour
+
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
 	// Step 1: Define what you expect to receive
 	type parameters struct {
@@ -24,6 +35,18 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = mailer.ValidateAddress(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.passwordPolicy.Validate(params.Password, params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
 	// Step 3: Hash pass
 	hashedPass, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -32,22 +55,35 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Step 4: Create user
-	user, err := cfg.dbQueries.CreateUser(r.Context(), database.CreateUserParams{
-		Email:    params.Email,
-		HashedPassword: hashedPass,
+	var convertedUser User
+	err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+		user, err := q.CreateUser(r.Context(), database.CreateUserParams{
+			Email:    params.Email,
+			HashedPassword: hashedPass,
+		})
+		if err != nil {
+			return err
+		}
+
+		convertedUser = User{
+	    ID:            user.ID,
+	    CreatedAt:     user.CreatedAt,
+	    UpdatedAt:     user.UpdatedAt,
+	    Email:         user.Email,
+	    IsChirpyRed:   user.IsChirpyRed,
+	    EmailVerified: user.EmailVerifiedAt.Valid,
+	    TwoFactorEnabled: user.TotpEnabledAt.Valid,
+	    Role:             user.Role,
+		}
+		return recordDomainEvent(r.Context(), q, eventUserCreated, aggregateUser, user.ID.String(), convertedUser)
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
 
-	convertedUser := User{
-    ID:          user.ID,
-    CreatedAt:   user.CreatedAt,
-    UpdatedAt:   user.UpdatedAt,
-    Email:       user.Email,
-    IsChirpyRed: user.IsChirpyRed,
-	}
+	// Step 5: Ask the user to confirm the address
+	cfg.queueEmail("verification", func() { cfg.sendEmailVerification(convertedUser.ID, convertedUser.Email) })
 
 	respondWithJSON(w, 201, convertedUser)
 }
@@ -58,12 +94,12 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Email 						string `json:"email"`
 		Password 					string `json:"password"`
 		ExpiresInSeconds  *int 	 `json:"expires_in_seconds"` // pointer = optional param
+		Scope 						string `json:"scope"` // optional, space separated subset of the default scopes
 	}
 
-	type response struct {
-		User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+	type mfaResponse struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
 	}
 
 	// Step 2: Decode the request body
@@ -75,48 +111,134 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Step 3: Get user by email
+	scopes, err := auth.ParseScopes(params.Scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scope", err)
+		return
+	}
+
+	// No password that long was ever set, don't hash it
+	err = cfg.passwordPolicy.CheckLength(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// Step 3: Refuse early while this email or IP is backing off
+	if !cfg.checkLoginThrottle(w, r, params.Email) {
+		return
+	}
+
+	// Step 4: Get user by email, unknown emails still pay for a hash check
 	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
+		auth.SimulatePasswordCheck(params.Password)
+		cfg.recordAudit(r, uuid.NullUUID{}, auditLoginFailed, auditTargetEmail, params.Email)
 		respondWithError(w, 401, "Incorrect email or password", err)
 		return
 	}
 
-	// Step 4: Compare passwords
+	// Step 5: Compare passwords
 	ok, err := auth.CheckPasswordHash(params.Password, user.HashedPassword)
 	if err != nil || !ok {
+		cfg.recordAudit(r, uuid.NullUUID{}, auditLoginFailed, auditTargetUser, user.ID.String())
 		respondWithError(w, 401, "Incorrect email or password", err)
 		return
 	}
+	// The account counter is only cleared after the second factor, so
+	// a known password can't be used to reset the guesses at the code
+	cfg.releaseLoginAttempt(r.Context(), params.Email, getClientIP(r))
 
-	// Step 5: Create JWT token
-	jwtToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate JWT token", err)
+	// Only told once the password is right, so it isn't an oracle
+	if user.SuspendedAt.Valid {
+		respondWithError(w, 403, "Account is suspended", nil)
 		return
 	}
 
-	// Step 6: Create and Save refresh token
-	now := time.Now()
-	expiration := now.Add(60 * 24 * time.Hour)  // 60 days from now
+	// The admin scope is only for those whose role can use it
+	if len(scopes) == 0 {
+		scopes = auth.DefaultScopesForRole(user.Role)
+	}
+	if scopesContain(scopes, auth.ScopeAdmin) && !auth.RoleAtLeast(user.Role, auth.RoleModerator) {
+		respondWithError(w, 403, "Only moderators and admins can ask for the admin scope", nil)
+		return
+	}
 
-	refreshTokenString, err := auth.MakeRefreshToken()
+	// Hashes made before the argon2id params were raised are upgraded while
+	// the plaintext is at hand. A failure here shouldn't block the login.
+	if auth.PasswordNeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r.Context(), user, params.Password)
+	}
+
+	// Step 6: With 2FA on, the password alone only earns an MFA token
+	if user.TotpEnabledAt.Valid {
+		mfaToken, err := auth.MakeMFAToken(user.ID, cfg.jwtKeys, mfaTokenTTL, scopes)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate MFA token", err)
+			return
+		}
+
+		respondWithJSON(w, 200, mfaResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	// Step 7: Logged in
+	cfg.clearLoginFailures(r.Context(), params.Email)
+	cfg.cancelAccountDeletion(r.Context(), user)
+	cfg.recordAudit(r, auditActor(user.ID), auditLoginSucceeded, auditTargetUser, user.ID.String())
+	cfg.respondWithNewSession(w, r, user, scopes)
+}
+
+// rehashPassword only replaces the exact hash that was checked, so it can't
+// undo a password change that landed in the meantime
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hashedPass, err := auth.HashPassword(password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate Refresh token", err)
+		log.Printf("Error rehashing password: %s", err)
 		return
 	}
 
-	savedRefreshTokenEntry, err := cfg.dbQueries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:    	refreshTokenString,
-		UserID: 		user.ID,
-		ExpiresAt:  expiration,
+	err = cfg.dbQueries.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		HashedPassword:   hashedPass,
+		ID:               user.ID,
+		HashedPassword_2: user.HashedPassword,
 	})
+	if err != nil {
+		log.Printf("Error saving rehashed password: %s", err)
+	}
+}
+
+// respondWithNewSession creates an access token and a refresh token for
+// the user and writes the login response
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User, scopes []string) {
+	type response struct {
+		User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// Step 1: Create and Save refresh token
+	refreshToken, err := cfg.createRefreshToken(r, user.ID, scopes, sql.NullString{})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save Refresh token", err)
 		return
 	}
 
-	// Step 7: Logged in
+	// Step 2: Create JWT token, tied to the session so logout can end it
+	jwtToken, err := auth.MakeJWT(auth.AccessToken{
+		UserID:    user.ID,
+		Role:      user.Role,
+		Scopes:    scopes,
+		SessionID: refreshToken.ID,
+	}, cfg.jwtKeys, accessTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate JWT token", err)
+		return
+	}
+
 	convertedUser := response{
     User: User{
 			ID:          user.ID,
@@ -124,14 +246,36 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 			UpdatedAt:   user.UpdatedAt,
 			Email:       user.Email,
 			IsChirpyRed: user.IsChirpyRed,
+			EmailVerified: user.EmailVerifiedAt.Valid,
+			TwoFactorEnabled: user.TotpEnabledAt.Valid,
+			Role:             user.Role,
     },
     Token: 				jwtToken,
-		RefreshToken: savedRefreshTokenEntry.Token,
+		RefreshToken: refreshToken.Token,
 	}
 
 	respondWithJSON(w, 200, convertedUser)
 }
 
+// createRefreshToken starts a session valid for 60 days. clientID is set
+// when an OAuth client asked for it.
+func (cfg *apiConfig) createRefreshToken(r *http.Request, userID uuid.UUID, scopes []string, clientID sql.NullString) (database.RefreshToken, error) {
+	refreshTokenString, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+
+	return cfg.dbQueries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		Token:     refreshTokenString,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(60 * 24 * time.Hour),
+		UserAgent: r.UserAgent(),
+		IpAddress: getClientIP(r),
+		Scope:     strings.Join(scopes, " "), // refreshed tokens never get more than this
+		ClientID:  clientID,
+	})
+}
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
     Token string `json:"token"`
@@ -145,7 +289,7 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. Check if there is such token in the DB
-	dbRefreshToken, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), tokenString)
+	dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), tokenString)
 	if err != nil {
 		respondWithError(w, 401, "Couldn't get Refresh token", err)
 		return
@@ -165,13 +309,58 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4. Create new JWT token
-	jwtToken, err := auth.MakeJWT(dbRefreshToken.UserID , cfg.jwtSecret, time.Hour)
+	// 4. Mark the session as used
+	err = cfg.dbQueries.TouchRefreshToken(r.Context(), database.TouchRefreshTokenParams{
+		Token:     tokenString,
+		IpAddress: getClientIP(r),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update session", err)
+		return
+	}
+
+	// 5. Create new JWT token with the user's current role. Tokens of
+	// OAuth apps never carry more than the user role and OAuth scopes,
+	// and stay marked with their client.
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), dbRefreshToken.UserID)
+	if err != nil {
+		respondWithError(w, 401, "Couldn't get user", err)
+		return
+	}
+
+	if user.SuspendedAt.Valid {
+		respondWithError(w, 403, "Account is suspended", nil)
+		return
+	}
+
+	role := user.Role
+	scopes := strings.Fields(dbRefreshToken.Scope)
+	if dbRefreshToken.ClientID.Valid {
+		role = auth.RoleUser
+		scopes = auth.FilterOAuthScopes(scopes)
+	} else if len(scopes) == 0 {
+		// Logins from before tokens had scopes were granted all of them
+		scopes = auth.DefaultScopes
+	}
+	if len(scopes) == 0 {
+		respondWithError(w, 403, "Session has no scopes left", nil)
+		return
+	}
+
+	jwtToken, err := auth.MakeJWT(auth.AccessToken{
+		UserID:    dbRefreshToken.UserID,
+		Role:      role,
+		Scopes:    scopes,
+		SessionID: dbRefreshToken.ID,
+		ClientID:  dbRefreshToken.ClientID.String,
+	}, cfg.jwtKeys, accessTokenTTL)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't generate JWT token", err)
 		return
 	}
 
+	cfg.recordAudit(r, auditActor(user.ID), auditTokenRefreshed, auditTargetSession, dbRefreshToken.ID.String())
+
 	convertedResponse := response{
     Token: jwtToken,
 	}
@@ -194,6 +383,75 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 3. Only tokens we know of are worth an audit event
+	dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), tokenString)
+	if err == nil {
+		cfg.recordAudit(r, auditActor(dbRefreshToken.UserID), auditTokenRevoked, auditTargetSession, dbRefreshToken.ID.String())
+	}
+
+	w.WriteHeader(http.StatusNoContent)  // 204
+}
+
+func (cfg *apiConfig) handlerLogout(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// 1. Any valid access token may log itself out
+	claims, ok := cfg.authenticateRequest(w, r, "")
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) { // the body is optional
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// 2. End the session the access token was issued with. Tokens from
+	// before access tokens carried a sid name the refresh token instead.
+	if claims.SessionID != "" {
+		sessionID, err := uuid.Parse(claims.SessionID)
+		if err != nil {
+			respondWithError(w, 401, "Invalid session", err)
+			return
+		}
+
+		_, err = cfg.dbQueries.RevokeSessionForUser(r.Context(), database.RevokeSessionForUserParams{
+			ID:     sessionID,
+			UserID: claims.UserID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh token", err)
+			return
+		}
+	}
+
+	// 3. Revoke the refresh token, but only if it belongs to the same user
+	if params.RefreshToken != "" {
+		dbRefreshToken, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), params.RefreshToken)
+		if err != nil || dbRefreshToken.UserID != claims.UserID {
+			respondWithError(w, 401, "Couldn't get Refresh token", err)
+			return
+		}
+
+		err = cfg.dbQueries.RevokeRefreshToken(r.Context(), params.RefreshToken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh token", err)
+			return
+		}
+	}
+
+	// 4. Deny the access token for the rest of its lifetime
+	err = cfg.denylist.Revoke(r.Context(), claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access token", err)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)  // 204
 }
 
@@ -204,39 +462,48 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 		Password string `json:"password"`
 	}
 
-	// Step 2. Get auth token from headers
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, 401, "Couldn't get bearer token", err)
-		return
+	type response struct {
+		User
+		PendingEmail string `json:"pending_email,omitempty"`
 	}
 
-	// Step 3. Check if token is valid
-	userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized", err)
+	// Step 2. Authenticate and check scope
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
 		return
 	}
+	userID := claims.UserID
 
-	// Step 4: Decode the request body
+	// Step 3: Decode the request body
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 
-	// Step 5: Hash pass
+	err = mailer.ValidateAddress(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.passwordPolicy.Validate(params.Password, params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// Step 4: Hash pass
 	hashedPass, err := auth.HashPassword(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
 	}
 
-	// Step 6: Update user
-	user, err := cfg.dbQueries.UpdateUser(r.Context(), database.UpdateUserParams{
-		Email:    			params.Email,
+	// Step 5: Update the password, the email only changes once it's verified
+	user, err := cfg.dbQueries.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
 		HashedPassword: hashedPass,
 		ID:					userID,
 	})
@@ -245,13 +512,180 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	convertedUser := User{
-    ID:          user.ID,
-    CreatedAt:   user.CreatedAt,
-    UpdatedAt:   user.UpdatedAt,
-    Email:       user.Email,
-    IsChirpyRed: user.IsChirpyRed,
+	pendingEmail := ""
+	if params.Email != user.Email {
+		pendingEmail = params.Email
+		cfg.queueEmail("verification", func() { cfg.sendEmailVerification(user.ID, pendingEmail) })
+	}
+
+	// Step 6: Password changed, so log out every other session
+	err = cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	cfg.recordAudit(r, auditActor(userID), auditUserUpdated, auditTargetUser, userID.String())
+
+	convertedUser := response{
+		User: User{
+			ID:            user.ID,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+			Email:         user.Email,
+			IsChirpyRed:   user.IsChirpyRed,
+			EmailVerified: user.EmailVerifiedAt.Valid,
+			TwoFactorEnabled: user.TotpEnabledAt.Valid,
+			Role:             user.Role,
+		},
+		PendingEmail: pendingEmail,
 	}
 
 	respondWithJSON(w, 200, convertedUser)
-}
\ No newline at end of file
+}
+
+func (cfg *apiConfig) handlerUsersPatch(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Every field is optional, only what's sent is changed
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	type response struct {
+		User
+		PendingEmail string `json:"pending_email,omitempty"`
+	}
+
+	// Step 2. Authenticate and check scope
+	claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	// Step 3: Decode the request body
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Email == nil && params.Password == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update", nil)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, 404, "Couldn't get user", err)
+		return
+	}
+
+	// Step 4: A stolen access token alone must not be enough to take the
+	// account over, wrong guesses count like failed logins
+	err = cfg.passwordPolicy.CheckLength(params.CurrentPassword)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if !cfg.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+
+	ok, err = auth.CheckPasswordHash(params.CurrentPassword, user.HashedPassword)
+	if err != nil || !ok {
+		respondWithError(w, 401, "Incorrect current password", err)
+		return
+	}
+	cfg.releaseLoginAttempt(r.Context(), user.Email, getClientIP(r))
+
+	// Step 5: Validate everything before changing anything. Emails compare
+	// exactly, like the unique constraint and GetUserByEmail, so a change
+	// in case is a new address that needs verifying.
+	pendingEmail := ""
+	if params.Email != nil && *params.Email != user.Email {
+		err = mailer.ValidateAddress(*params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+
+		_, err = cfg.dbQueries.GetUserByEmail(r.Context(), *params.Email)
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+			return
+		}
+		pendingEmail = *params.Email
+	}
+
+	if params.Password != nil {
+		err = cfg.passwordPolicy.Validate(*params.Password, user.Email)
+		if err == nil && pendingEmail != "" {
+			err = cfg.passwordPolicy.Validate(*params.Password, pendingEmail)
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	cfg.clearLoginFailures(r.Context(), user.Email)
+
+	// Step 6: Password changed, so log out every other session
+	if params.Password != nil {
+		hashedPass, err := auth.HashPassword(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+
+		err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+			var err error
+			user, err = q.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+				HashedPassword: hashedPass,
+				ID:             user.ID,
+			})
+			if err != nil {
+				return err
+			}
+			return recordDomainEvent(r.Context(), q, eventUserUpdated, aggregateUser, user.ID.String(), convertAdminUser(user))
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+			return
+		}
+
+		err = cfg.dbQueries.RevokeAllSessionsForUser(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+			return
+		}
+	}
+
+	// Step 7: The email only changes once the new address is verified
+	if pendingEmail != "" {
+		cfg.queueEmail("verification", func() { cfg.sendEmailVerification(user.ID, pendingEmail) })
+	}
+
+	cfg.recordAudit(r, auditActor(user.ID), auditUserUpdated, auditTargetUser, user.ID.String())
+
+	respondWithJSON(w, 200, response{
+		User: User{
+			ID:               user.ID,
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+			Email:            user.Email,
+			IsChirpyRed:      user.IsChirpyRed,
+			EmailVerified:    user.EmailVerifiedAt.Valid,
+			TwoFactorEnabled: user.TotpEnabledAt.Valid,
+			Role:             user.Role,
+		},
+		PendingEmail: pendingEmail,
+	})
+}
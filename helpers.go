@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
+	"net"
+	"net/http"
 	"strings"
+	"github.com/lib/pq"
 )
 
 func replaceBadWords(msg string) string {
@@ -16,4 +20,19 @@ func replaceBadWords(msg string) string {
 
 	result := strings.Join(words, " ")
 	return result
-}
\ No newline at end of file
+}
+
+// getClientIP returns the remote IP of the request without the port
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
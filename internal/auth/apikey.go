@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// apiKeyTag starts every personal API key, so leaked keys are easy to spot
+// in logs and by secret scanners
+const apiKeyTag = "chirpy_"
+
+// MakeAPIKey returns a new personal API key and its prefix. Only the hash
+// of the key is stored, the prefix is kept in the clear so users can tell
+// their keys apart.
+func MakeAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 4)
+	_, err = rand.Read(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix = apiKeyTag + hex.EncodeToString(id)
+	return prefix + "_" + hex.EncodeToString(secret), prefix, nil
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMakeAPIKey(t *testing.T) {
+	key, prefix, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey() error = %v", err)
+	}
+
+	if !strings.HasPrefix(prefix, "chirpy_") || len(prefix) != len("chirpy_")+8 {
+		t.Errorf("MakeAPIKey() prefix = %q, want chirpy_ and 8 hex characters", prefix)
+	}
+	if !strings.HasPrefix(key, prefix+"_") || len(key) != len(prefix)+1+64 {
+		t.Errorf("MakeAPIKey() key = %q, want the prefix and 64 hex characters", key)
+	}
+
+	other, _, _ := MakeAPIKey()
+	if other == key {
+		t.Errorf("MakeAPIKey() returned the same key twice")
+	}
+}
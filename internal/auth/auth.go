@@ -7,9 +7,12 @@ import (
 	"time"
 	"errors"
 	"strings"
+	"sync"
 	"net/http"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 )
 
 type TokenType string
@@ -17,30 +20,235 @@ type TokenType string
 const (
 	// TokenTypeAccess -
 	TokenTypeAccess TokenType = "chirpy-access"
+	// TokenTypeMFA is only good for finishing a login with a 2FA code
+	TokenTypeMFA TokenType = "chirpy-mfa"
 )
 
+// Audience is the `aud` every access token is minted for
+const Audience = "chirpy-api"
+
+const (
+	// ScopeChirpsWrite allows creating and deleting chirps
+	ScopeChirpsWrite = "chirps:write"
+	// ScopeUsersRead allows reading the user's own account data
+	ScopeUsersRead = "users:read"
+	// ScopeUsersWrite allows changing the user's account and sessions
+	ScopeUsersWrite = "users:write"
+	// ScopeAdmin allows using the /admin routes, on top of the role each
+	// of them needs
+	ScopeAdmin = "admin"
+)
+
+// DefaultScopes are granted to tokens issued by a normal password login
+var DefaultScopes = []string{ScopeChirpsWrite, ScopeUsersRead, ScopeUsersWrite}
+
+// knownScopes are all scopes a token may ask for
+var knownScopes = []string{ScopeChirpsWrite, ScopeUsersRead, ScopeUsersWrite, ScopeAdmin}
+
+// DefaultScopesForRole are the scopes a login gets when it doesn't ask for
+// any: DefaultScopes, plus ScopeAdmin for moderators and admins
+func DefaultScopesForRole(role string) []string {
+	if RoleAtLeast(role, RoleModerator) {
+		return knownScopes
+	}
+	return DefaultScopes
+}
+
+// Claims are the claims carried by a Chirpy access token
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"` // space separated, like OAuth2
+	Role  string `json:"role,omitempty"`
+	// SessionID is the ID of the refresh token the access token was issued
+	// with, empty for tokens that aren't part of a session
+	SessionID string `json:"sid,omitempty"`
+	// ClientID is the OAuth app the token was issued to, empty for
+	// first-party logins
+	ClientID string `json:"client_id,omitempty"`
+
+	UserID uuid.UUID `json:"-"`
+}
+
+// AccessToken is what MakeJWT puts into an access token
+type AccessToken struct {
+	UserID uuid.UUID
+	// Role is a snapshot, a change only shows up in tokens issued after it
+	Role   string
+	Scopes []string // must not be empty
+	// SessionID links the token to its refresh token, uuid.Nil for none
+	SessionID uuid.UUID
+	// ClientID is set for tokens issued to an OAuth app
+	ClientID string
+}
+
+// Scopes splits the scope claim into a list
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the token was granted the given scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes turns a space separated scope string into a list, rejecting
+// anything that isn't a known scope. An empty string is no scopes at all,
+// callers pick their own default.
+func ParseScopes(scope string) ([]string, error) {
+	requested := strings.Fields(scope)
+	for _, s := range requested {
+		known := false
+		for _, d := range knownScopes {
+			if s == d {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+	}
+
+	return requested, nil
+}
+
+var (
+	passwordParamsMu sync.RWMutex
+	passwordParams   = *argon2id.DefaultParams
+	dummyHash        string // what unknown emails get checked against
+)
+
+// SetPasswordParams changes the argon2id parameters new hashes are created
+// with. Existing hashes keep working, PasswordNeedsRehash reports which
+// ones are behind.
+func SetPasswordParams(params argon2id.Params) error {
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return errors.New("argon2id iterations and parallelism must be at least 1")
+	}
+	if params.Memory < 8*uint32(params.Parallelism) {
+		return fmt.Errorf("argon2id memory must be at least %d KiB for parallelism %d", 8*uint32(params.Parallelism), params.Parallelism)
+	}
+	if params.SaltLength < 16 || params.KeyLength < 16 {
+		return errors.New("argon2id salt and key must be at least 16 bytes")
+	}
+
+	passwordParamsMu.Lock()
+	defer passwordParamsMu.Unlock()
+	passwordParams = params
+	dummyHash = ""
+	return nil
+}
+
+// PasswordParams returns the argon2id parameters new hashes are created with
+func PasswordParams() argon2id.Params {
+	passwordParamsMu.RLock()
+	defer passwordParamsMu.RUnlock()
+	return passwordParams
+}
+
 func HashPassword(password string) (string, error) {
-	return argon2id.CreateHash(password, argon2id.DefaultParams)
+	params := PasswordParams()
+	return argon2id.CreateHash(password, &params)
 }
 
 func CheckPasswordHash(password, hash string) (bool, error) {
 	return argon2id.ComparePasswordAndHash(password, hash)
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
+// PasswordNeedsRehash reports whether the hash was made with weaker
+// parameters than the current ones: less memory, fewer iterations or a
+// shorter salt or key. Lowering the params never downgrades stronger
+// hashes. Call it after a successful CheckPasswordHash, while the
+// plaintext is still at hand.
+func PasswordNeedsRehash(hash string) bool {
+	params, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return true
+	}
+	current := PasswordParams()
+	return params.Memory < current.Memory ||
+		params.Iterations < current.Iterations ||
+		params.SaltLength < current.SaltLength ||
+		params.KeyLength < current.KeyLength
+}
+
+// SimulatePasswordCheck does the same argon2id work as CheckPasswordHash
+// for a login with an unknown email, so response times don't reveal which
+// accounts exist
+func SimulatePasswordCheck(password string) {
+	passwordParamsMu.Lock()
+	if dummyHash == "" {
+		hash, err := argon2id.CreateHash("chirpy-dummy-password", &passwordParams)
+		if err != nil {
+			passwordParamsMu.Unlock()
+			return
+		}
+		dummyHash = hash
+	}
+	hash := dummyHash
+	passwordParamsMu.Unlock()
+
+	argon2id.ComparePasswordAndHash(password, hash)
+}
+
+// MakeJWT issues an access token
+func MakeJWT(accessToken AccessToken, keys *KeyRing, expiresIn time.Duration) (string, error) {
+	claims := Claims{
+		Role:     accessToken.Role,
+		ClientID: accessToken.ClientID,
+	}
+	if accessToken.SessionID != uuid.Nil {
+		claims.SessionID = accessToken.SessionID.String()
+	}
+	return makeToken(TokenTypeAccess, accessToken.UserID, claims, keys, expiresIn, accessToken.Scopes)
+}
+
+func ValidateJWT(tokenString string, keys *KeyRing) (*Claims, error) {
+	return validateToken(TokenTypeAccess, tokenString, keys)
+}
+
+// MakeMFAToken issues the short-lived token handed out after a correct
+// password when 2FA is enabled. It carries the scopes the login asked for.
+func MakeMFAToken(userID uuid.UUID, keys *KeyRing, expiresIn time.Duration, scopes []string) (string, error) {
+	return makeToken(TokenTypeMFA, userID, Claims{}, keys, expiresIn, scopes)
+}
+
+// ValidateMFAToken is ValidateJWT for MFA tokens. The issuers differ, so
+// neither kind of token is accepted in place of the other.
+func ValidateMFAToken(tokenString string, keys *KeyRing) (*Claims, error) {
+	return validateToken(TokenTypeMFA, tokenString, keys)
+}
+
+// makeToken fills in the registered claims and the scopes of claims and
+// signs them
+func makeToken(tokenType TokenType, userID uuid.UUID, claims Claims, keys *KeyRing, expiresIn time.Duration, scopes []string) (string, error) {
+	// A token without scopes is a bug in the caller, never a full grant
+	if len(scopes) == 0 {
+		return "", errors.New("token has no scopes")
+	}
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:	 userID.String(),
+		Audience:  jwt.ClaimStrings{Audience},
+		ID:        uuid.NewString(), // jti
 	}
+	claims.Scope = strings.Join(scopes, " ")
 
-	// Creating a token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Creating a token with claims, kid tells verifiers which key to use
+	signingKey := keys.Current()
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.ID
 
 	// Signing the token
-	secretKey := []byte(tokenSecret)
-	signedString, err := token.SignedString(secretKey)
+	signedString, err := token.SignedString(signingKey.private)
 	if err != nil {
     return "", err
 	}
@@ -48,31 +256,41 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 	return signedString, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-    return []byte(tokenSecret), nil
-	})
+func validateToken(tokenType TokenType, tokenString string, keys *KeyRing) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		signingKey, err := keys.Lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		// Never let the token pick a different algorithm than the key's
+		if token.Method.Alg() != signingKey.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+    return signingKey.public, nil
+	}, jwt.WithAudience(Audience), jwt.WithIssuer(string(tokenType)))
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 
 	// Type assertion pattern
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	claims, ok := token.Claims.(*Claims)
 	if !ok {
-		return uuid.Nil, errors.New("couldn't parse claims")
+		return nil, errors.New("couldn't parse claims")
 	}
 
-	if claims.Issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+	if claims.ID == "" {
+		return nil, errors.New("token has no jti")
 	}
 
 	// From string to UUID
 	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
+	claims.UserID = id
 
-	return id, nil
+	return claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -103,6 +321,14 @@ func MakeRefreshToken() (string, error) {
 	return hexString, nil
 }
 
+// HashToken returns the SHA-256 of a random single-use token, so only the
+// hash needs to be stored. Unlike passwords these tokens have full entropy,
+// so a fast hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
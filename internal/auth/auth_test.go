@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/alexedwards/argon2id"
 	"github.com/google/uuid"
 )
 
@@ -71,9 +73,58 @@ func TestCheckPasswordHash(t *testing.T) {
 	}
 }
 
+func TestPasswordNeedsRehash(t *testing.T) {
+	original := PasswordParams()
+	t.Cleanup(func() { SetPasswordParams(original) })
+
+	weak := argon2id.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	strong := argon2id.Params{Memory: 2048, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	if err := SetPasswordParams(weak); err != nil {
+		t.Fatalf("SetPasswordParams() error = %v", err)
+	}
+	hash, _ := HashPassword("correctPassword123!")
+	if PasswordNeedsRehash(hash) {
+		t.Errorf("PasswordNeedsRehash() = true for a hash with the current params")
+	}
+
+	if err := SetPasswordParams(strong); err != nil {
+		t.Fatalf("SetPasswordParams() error = %v", err)
+	}
+	if !PasswordNeedsRehash(hash) {
+		t.Errorf("PasswordNeedsRehash() = false after the params were strengthened")
+	}
+
+	// Going back down leaves the stronger hashes alone
+	strongHash, _ := HashPassword("correctPassword123!")
+	if err := SetPasswordParams(weak); err != nil {
+		t.Fatalf("SetPasswordParams() error = %v", err)
+	}
+	if PasswordNeedsRehash(strongHash) {
+		t.Errorf("PasswordNeedsRehash() = true for a hash stronger than the params")
+	}
+	if err := SetPasswordParams(strong); err != nil {
+		t.Fatalf("SetPasswordParams() error = %v", err)
+	}
+
+	// Old hashes still verify after the change
+	match, err := CheckPasswordHash("correctPassword123!", hash)
+	if err != nil || !match {
+		t.Errorf("CheckPasswordHash() = %v, %v for a hash with old params", match, err)
+	}
+
+	if !PasswordNeedsRehash("invalidhash") {
+		t.Errorf("PasswordNeedsRehash() = false for an invalid hash")
+	}
+
+	if err := SetPasswordParams(argon2id.Params{Memory: 4, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}); err == nil {
+		t.Errorf("SetPasswordParams() accepted memory below 8 KiB per lane")
+	}
+}
+
 func TestValidateJWT(t *testing.T) {
 	userID := uuid.New()
-	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	validToken, _ := MakeJWT(AccessToken{UserID: userID, Role: RoleUser, Scopes: DefaultScopes}, NewHMACKeyRing("secret"), time.Hour)
 
 	tests := []struct {
 		name        string
@@ -107,11 +158,15 @@ func TestValidateJWT(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotUserID, err := ValidateJWT(tt.tokenString, tt.tokenSecret)
+			claims, err := ValidateJWT(tt.tokenString, NewHMACKeyRing(tt.tokenSecret))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			gotUserID := uuid.Nil
+			if claims != nil {
+				gotUserID = claims.UserID
+			}
 			if gotUserID != tt.wantUserID {
 				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, tt.wantUserID)
 			}
@@ -119,6 +174,97 @@ func TestValidateJWT(t *testing.T) {
 	}
 }
 
+func TestValidateJWTClaims(t *testing.T) {
+	keys := NewHMACKeyRing("secret")
+	userID := uuid.New()
+
+	token, err := MakeJWT(AccessToken{UserID: userID, Role: RoleUser, Scopes: []string{ScopeChirpsWrite}}, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+
+	claims, err := ValidateJWT(token, keys)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if claims.ID == "" {
+		t.Errorf("ValidateJWT() claims have no jti")
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != Audience {
+		t.Errorf("ValidateJWT() audience = %v, want %v", claims.Audience, Audience)
+	}
+	if !claims.HasScope(ScopeChirpsWrite) {
+		t.Errorf("HasScope(%q) = false, want true", ScopeChirpsWrite)
+	}
+	if claims.HasScope(ScopeUsersWrite) {
+		t.Errorf("HasScope(%q) = true, want false", ScopeUsersWrite)
+	}
+
+	if claims.SessionID != "" {
+		t.Errorf("ValidateJWT() sid = %q for a token without a session", claims.SessionID)
+	}
+
+	// Two tokens for the same user never share a jti
+	sessionID := uuid.New()
+	other, _ := MakeJWT(AccessToken{UserID: userID, Role: RoleUser, Scopes: DefaultScopes, SessionID: sessionID}, keys, time.Hour)
+	otherClaims, _ := ValidateJWT(other, keys)
+	if otherClaims.ID == claims.ID {
+		t.Errorf("MakeJWT() reused jti %v", claims.ID)
+	}
+	if otherClaims.SessionID != sessionID.String() {
+		t.Errorf("ValidateJWT() sid = %q, want %v", otherClaims.SessionID, sessionID)
+	}
+
+	// Forgetting the scopes must not grant all of them
+	if _, err := MakeJWT(AccessToken{UserID: userID, Role: RoleUser}, keys, time.Hour); err == nil {
+		t.Errorf("MakeJWT() without scopes should fail")
+	}
+	if _, err := MakeMFAToken(userID, keys, time.Minute, []string{}); err == nil {
+		t.Errorf("MakeMFAToken() without scopes should fail")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name       string
+		scope      string
+		wantScopes int
+		wantErr    bool
+	}{
+		{
+			name:       "Empty means none",
+			scope:      "",
+			wantScopes: 0,
+			wantErr:    false,
+		},
+		{
+			name:       "Subset",
+			scope:      "chirps:write",
+			wantScopes: 1,
+			wantErr:    false,
+		},
+		{
+			name:       "Unknown scope",
+			scope:      "chirps:write admin:everything",
+			wantScopes: 0,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes, err := ParseScopes(tt.scope)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseScopes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(scopes) != tt.wantScopes {
+				t.Errorf("ParseScopes() got %d scopes, want %d", len(scopes), tt.wantScopes)
+			}
+		})
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -162,4 +308,19 @@ func TestGetBearerToken(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestHashToken(t *testing.T) {
+	token, _ := MakeRefreshToken()
+
+	if HashToken(token) != HashToken(token) {
+		t.Errorf("HashToken() is not deterministic")
+	}
+	if HashToken(token) == token {
+		t.Errorf("HashToken() returned the token itself")
+	}
+	other, _ := MakeRefreshToken()
+	if HashToken(token) == HashToken(other) {
+		t.Errorf("HashToken() collided for different tokens")
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DenylistStore persists revoked access token IDs so every instance sees them
+type DenylistStore interface {
+	RevokeAccessToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// Denylist answers "has this access token been revoked?" from an in-process
+// LRU cache, falling back to the store on a miss. Revocations are cached
+// until the token expires; "not revoked" answers only for notRevokedTTL so
+// revocations made by other instances are picked up quickly.
+type Denylist struct {
+	store         DenylistStore
+	notRevokedTTL time.Duration
+
+	mu    sync.Mutex
+	cache *lruCache
+}
+
+func NewDenylist(store DenylistStore, cacheSize int, notRevokedTTL time.Duration) *Denylist {
+	return &Denylist{
+		store:         store,
+		notRevokedTTL: notRevokedTTL,
+		cache:         newLRUCache(cacheSize),
+	}
+}
+
+// Revoke denies the access token described by claims until it expires
+func (d *Denylist) Revoke(ctx context.Context, claims *Claims) error {
+	expiresAt := time.Now().UTC()
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	err := d.store.RevokeAccessToken(ctx, claims.ID, claims.UserID, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.cache.put(claims.ID, true, expiresAt)
+	d.mu.Unlock()
+
+	return nil
+}
+
+// IsRevoked reports whether the token with these claims has been revoked
+func (d *Denylist) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	now := time.Now().UTC()
+
+	d.mu.Lock()
+	revoked, found := d.cache.get(claims.ID, now)
+	d.mu.Unlock()
+	if found {
+		return revoked, nil
+	}
+
+	revoked, err := d.store.IsAccessTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return false, err
+	}
+
+	cacheUntil := now.Add(d.notRevokedTTL)
+	if revoked && claims.ExpiresAt != nil {
+		cacheUntil = claims.ExpiresAt.Time
+	}
+
+	d.mu.Lock()
+	d.cache.put(claims.ID, revoked, cacheUntil)
+	d.mu.Unlock()
+
+	return revoked, nil
+}
+
+type lruEntry struct {
+	key       string
+	revoked   bool
+	expiresAt time.Time
+}
+
+// lruCache is a fixed size least-recently-used cache. Not safe for
+// concurrent use, the Denylist holds the lock.
+type lruCache struct {
+	size    int
+	order   *list.List // front = most recently used
+	entries map[string]*list.Element
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string, now time.Time) (bool, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if now.After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return false, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.revoked, true
+}
+
+func (c *lruCache) put(key string, revoked bool, expiresAt time.Time) {
+	if c.size <= 0 {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.revoked = revoked
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, revoked: revoked, expiresAt: expiresAt})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type fakeDenylistStore struct {
+	revoked map[string]bool
+	lookups int
+}
+
+func (s *fakeDenylistStore) RevokeAccessToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error {
+	s.revoked[jti] = true
+	return nil
+}
+
+func (s *fakeDenylistStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.lookups++
+	return s.revoked[jti], nil
+}
+
+func testClaims() *Claims {
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		UserID: uuid.New(),
+	}
+}
+
+func TestDenylistRevoke(t *testing.T) {
+	store := &fakeDenylistStore{revoked: map[string]bool{}}
+	denylist := NewDenylist(store, 10, time.Minute)
+	ctx := context.Background()
+
+	claims := testClaims()
+	revoked, err := denylist.IsRevoked(ctx, claims)
+	if err != nil || revoked {
+		t.Fatalf("IsRevoked() = %v, %v, want false, nil", revoked, err)
+	}
+
+	// The "not revoked" answer is cached, but a local revoke overrides it
+	denylist.Revoke(ctx, claims)
+	revoked, _ = denylist.IsRevoked(ctx, claims)
+	if !revoked {
+		t.Errorf("IsRevoked() after Revoke() = false, want true")
+	}
+	if store.lookups != 1 {
+		t.Errorf("store lookups = %d, want 1", store.lookups)
+	}
+}
+
+func TestDenylistSeesOtherInstances(t *testing.T) {
+	store := &fakeDenylistStore{revoked: map[string]bool{}}
+	denylist := NewDenylist(store, 10, 0)
+	ctx := context.Background()
+
+	claims := testClaims()
+	denylist.IsRevoked(ctx, claims)
+
+	// Revoked directly in the store, as another instance would
+	store.revoked[claims.ID] = true
+	time.Sleep(time.Millisecond)
+
+	revoked, _ := denylist.IsRevoked(ctx, claims)
+	if !revoked {
+		t.Errorf("IsRevoked() = false once the cached answer expired, want true")
+	}
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	cache := newLRUCache(2)
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	cache.put("a", true, later)
+	cache.put("b", true, later)
+	cache.get("a", now) // a is now the most recently used
+	cache.put("c", true, later)
+
+	if _, found := cache.get("b", now); found {
+		t.Errorf("least recently used entry b should have been evicted")
+	}
+	if _, found := cache.get("a", now); !found {
+		t.Errorf("entry a should still be cached")
+	}
+	if _, found := cache.get("c", now); !found {
+		t.Errorf("entry c should still be cached")
+	}
+}
@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// AlgHS256 signs with a shared secret (the old single SECRET behaviour)
+	AlgHS256 = "HS256"
+	// AlgRS256 signs with a 2048 bit RSA key
+	AlgRS256 = "RS256"
+	// AlgEdDSA signs with an Ed25519 key
+	AlgEdDSA = "EdDSA"
+)
+
+// reloadInterval limits how often a token with an unknown kid makes a key
+// ring re-read its directory
+const reloadInterval = 10 * time.Second
+
+// SigningKey is one key in a KeyRing, identified by the `kid` JWT header
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	CreatedAt time.Time
+	RetiredAt time.Time // zero while this is the current signing key
+
+	private interface{}
+	public  interface{}
+}
+
+// KeyRing holds the current signing key plus retired keys that can still
+// verify tokens issued before a rotation
+type KeyRing struct {
+	mu     sync.RWMutex
+	keys   []*SigningKey
+	alg    string
+	dir    string        // where generated keys are persisted, empty = memory only
+	retain time.Duration // how long a retired key keeps verifying tokens
+
+	reloadedAt time.Time
+}
+
+// NewHMACKeyRing wraps a single shared secret so HS256 keeps working
+func NewHMACKeyRing(secret string) *KeyRing {
+	return &KeyRing{
+		alg: AlgHS256,
+		keys: []*SigningKey{{
+			ID:        "hs256",
+			Method:    jwt.SigningMethodHS256,
+			CreatedAt: time.Now().UTC(),
+			private:   []byte(secret),
+			public:    []byte(secret),
+		}},
+	}
+}
+
+// NewKeyRing creates an asymmetric key ring. Existing PEM keys in dir are
+// loaded (newest file is the current signing key); if there are none a new
+// key is generated. An empty dir keeps keys in memory only. Instances that
+// share dir pick up each other's rotations: a token with an unknown kid
+// makes the ring read dir again. retain must be at least the longest
+// lifetime of a token signed with the ring.
+func NewKeyRing(alg, dir string, retain time.Duration) (*KeyRing, error) {
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	kr := &KeyRing{
+		alg:    alg,
+		dir:    dir,
+		retain: retain,
+	}
+
+	if dir != "" {
+		keys, err := kr.readDir()
+		if err != nil {
+			return nil, err
+		}
+		kr.setKeys(keys)
+		kr.reloadedAt = time.Now()
+	}
+
+	if len(kr.keys) == 0 {
+		_, err := kr.Rotate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kr, nil
+}
+
+// readDir returns the keys saved in dir
+func (kr *KeyRing) readDir() ([]*SigningKey, error) {
+	files, err := filepath.Glob(filepath.Join(kr.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*SigningKey{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data found", file)
+		}
+
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		key, err := newSigningKey(strings.TrimSuffix(filepath.Base(file), ".pem"), private)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		// Only keys matching the configured algorithm take part
+		if key.Method.Alg() != kr.alg {
+			continue
+		}
+		key.CreatedAt = info.ModTime().UTC()
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// setKeys replaces the keys with ones read from dir. Caller holds the lock
+// or is the constructor.
+func (kr *KeyRing) setKeys(keys []*SigningKey) {
+	// Oldest first, every key is retired by the one that replaced it
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	for i := 0; i < len(keys)-1; i++ {
+		keys[i].RetiredAt = keys[i+1].CreatedAt
+	}
+	kr.keys = keys
+	kr.prune(time.Now().UTC())
+}
+
+// reload reads dir again, at most once per reloadInterval, reporting
+// whether it did
+func (kr *KeyRing) reload() bool {
+	if kr.dir == "" {
+		return false
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if time.Since(kr.reloadedAt) < reloadInterval {
+		return false
+	}
+	kr.reloadedAt = time.Now()
+
+	keys, err := kr.readDir()
+	if err != nil || len(keys) == 0 {
+		return false
+	}
+	kr.setKeys(keys)
+	return true
+}
+
+func newSigningKey(kid string, private interface{}) (*SigningKey, error) {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+}
+
+func generatePrivateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	default:
+		return nil, fmt.Errorf("can't generate keys for %q", alg)
+	}
+}
+
+// Rotate generates a new signing key and retires the current one. Retired
+// keys keep verifying tokens for the retain period, then get dropped.
+func (kr *KeyRing) Rotate() (*SigningKey, error) {
+	if kr.alg == AlgHS256 {
+		return nil, errors.New("HS256 keys can't be rotated automatically")
+	}
+
+	private, err := generatePrivateKey(kr.alg)
+	if err != nil {
+		return nil, err
+	}
+
+	kidBytes := make([]byte, 8)
+	_, err = rand.Read(kidBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newSigningKey(hex.EncodeToString(kidBytes), private)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = time.Now().UTC()
+
+	if kr.dir != "" {
+		err = kr.save(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if len(kr.keys) > 0 {
+		kr.keys[len(kr.keys)-1].RetiredAt = key.CreatedAt
+	}
+	kr.keys = append(kr.keys, key)
+	kr.prune(key.CreatedAt)
+
+	return key, nil
+}
+
+func (kr *KeyRing) save(key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(kr.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return os.WriteFile(filepath.Join(kr.dir, key.ID+".pem"), data, 0600)
+}
+
+// prune drops retired keys whose retain period is over. Caller holds the lock.
+func (kr *KeyRing) prune(now time.Time) {
+	kept := kr.keys[:0]
+	for _, key := range kr.keys {
+		if !key.RetiredAt.IsZero() && now.After(key.RetiredAt.Add(kr.retain)) {
+			if kr.dir != "" {
+				os.Remove(filepath.Join(kr.dir, key.ID+".pem"))
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	kr.keys = kept
+}
+
+// Current returns the key new tokens are signed with
+func (kr *KeyRing) Current() *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[len(kr.keys)-1]
+}
+
+// Lookup finds a key that may still verify tokens. An empty kid is only
+// accepted for single-key rings so tokens minted before `kid` existed
+// keep working.
+func (kr *KeyRing) Lookup(kid string) (*SigningKey, error) {
+	key, err := kr.lookup(kid)
+	if errors.Is(err, errUnknownKey) && kr.reload() {
+		// Another instance sharing dir may have rotated
+		key, err = kr.lookup(kid)
+	}
+	return key, err
+}
+
+var errUnknownKey = errors.New("unknown signing key")
+
+func (kr *KeyRing) lookup(kid string) (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if kid == "" {
+		if len(kr.keys) == 1 {
+			return kr.keys[0], nil
+		}
+		return nil, errors.New("token has no kid header")
+	}
+
+	now := time.Now().UTC()
+	for _, key := range kr.keys {
+		if key.ID != kid {
+			continue
+		}
+		if !key.RetiredAt.IsZero() && now.After(key.RetiredAt.Add(kr.retain)) {
+			return nil, errors.New("signing key has been retired")
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w %q", errUnknownKey, kid)
+}
+
+// JWK is a public key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every verification key. Shared HMAC
+// secrets are never published.
+func (kr *KeyRing) JWKS() JWKS {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range kr.keys {
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return set
+}
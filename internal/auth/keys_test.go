@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestKeyRingSignAndVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		alg  string
+	}{
+		{name: "RS256", alg: AlgRS256},
+		{name: "EdDSA", alg: AlgEdDSA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeyRing(tt.alg, "", time.Hour)
+			if err != nil {
+				t.Fatalf("NewKeyRing() error = %v", err)
+			}
+
+			userID := uuid.New()
+			token, err := MakeJWT(AccessToken{UserID: userID, Role: RoleUser, Scopes: DefaultScopes}, keys, time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT() error = %v", err)
+			}
+
+			claims, err := ValidateJWT(token, keys)
+			if err != nil {
+				t.Fatalf("ValidateJWT() error = %v", err)
+			}
+			if claims.UserID != userID {
+				t.Errorf("ValidateJWT() gotUserID = %v, want %v", claims.UserID, userID)
+			}
+
+			otherKeys, _ := NewKeyRing(tt.alg, "", time.Hour)
+			if _, err := ValidateJWT(token, otherKeys); err == nil {
+				t.Errorf("ValidateJWT() with a different key ring should fail")
+			}
+		})
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	keys, err := NewKeyRing(AlgEdDSA, "", time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	userID := uuid.New()
+	oldToken, _ := MakeJWT(AccessToken{UserID: userID, Role: RoleUser, Scopes: DefaultScopes}, keys, time.Hour)
+	oldKid := keys.Current().ID
+
+	newKey, err := keys.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if newKey.ID == oldKid {
+		t.Fatalf("Rotate() kept the same kid %v", oldKid)
+	}
+
+	// Tokens signed before the rotation keep validating
+	if _, err := ValidateJWT(oldToken, keys); err != nil {
+		t.Errorf("ValidateJWT() of pre-rotation token error = %v", err)
+	}
+
+	if got := len(keys.JWKS().Keys); got != 2 {
+		t.Errorf("JWKS() has %d keys, want 2", got)
+	}
+}
+
+func TestKeyRingRetiredKeyIsDropped(t *testing.T) {
+	keys, err := NewKeyRing(AlgEdDSA, "", 0)
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	oldToken, _ := MakeJWT(AccessToken{UserID: uuid.New(), Role: RoleUser, Scopes: DefaultScopes}, keys, time.Hour)
+	time.Sleep(time.Millisecond)
+	keys.Rotate()
+	time.Sleep(time.Millisecond)
+
+	if _, err := ValidateJWT(oldToken, keys); err == nil {
+		t.Errorf("ValidateJWT() should reject tokens from a retired key")
+	}
+}
+
+func TestKeyRingPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := NewKeyRing(AlgRS256, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+	token, _ := MakeJWT(AccessToken{UserID: uuid.New(), Role: RoleUser, Scopes: DefaultScopes}, keys, time.Hour)
+
+	reloaded, err := NewKeyRing(AlgRS256, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyRing() reload error = %v", err)
+	}
+	if reloaded.Current().ID != keys.Current().ID {
+		t.Errorf("reloaded kid = %v, want %v", reloaded.Current().ID, keys.Current().ID)
+	}
+	if _, err := ValidateJWT(token, reloaded); err != nil {
+		t.Errorf("ValidateJWT() with reloaded keys error = %v", err)
+	}
+}
+
+func TestKeyRingPicksUpRotationFromSharedDir(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := NewKeyRing(AlgEdDSA, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+	other, err := NewKeyRing(AlgEdDSA, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyRing() second instance error = %v", err)
+	}
+	// Let the other instance reload right away
+	other.reloadedAt = time.Time{}
+
+	if _, err := keys.Rotate(); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	token, _ := MakeJWT(AccessToken{UserID: uuid.New(), Role: RoleUser, Scopes: DefaultScopes}, keys, time.Hour)
+
+	if _, err := ValidateJWT(token, other); err != nil {
+		t.Errorf("ValidateJWT() on the other instance error = %v", err)
+	}
+	if _, err := other.Lookup("unknown"); err == nil {
+		t.Errorf("Lookup() of an unknown kid should fail")
+	}
+}
+
+func TestHMACKeysAreNotPublished(t *testing.T) {
+	keys := NewHMACKeyRing("secret")
+	if got := len(keys.JWKS().Keys); got != 0 {
+		t.Errorf("JWKS() published %d HMAC keys", got)
+	}
+}
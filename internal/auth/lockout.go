@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"time"
+)
+
+// LockoutPolicy decides how long to refuse logins after failed attempts.
+// The first FreeAttempts failures cost nothing, after that every failure
+// doubles the wait starting at BaseDelay (capped at MaxDelay), and from
+// MaxAttempts on the key is locked out for LockoutDuration. Without
+// MaxAttempts it's only ever slowed down. A key that hasn't failed for
+// ResetAfter starts over.
+type LockoutPolicy struct {
+	FreeAttempts    int
+	MaxAttempts     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutDuration time.Duration
+	ResetAfter      time.Duration
+}
+
+// BlockedUntil returns when the next attempt is allowed, given the number
+// of consecutive failures and the time of the last one. A time in the past
+// means "go ahead".
+func (p LockoutPolicy) BlockedUntil(failures int, lastFailure time.Time) time.Time {
+	if failures < p.FreeAttempts {
+		return time.Time{}
+	}
+	if p.ResetAfter > 0 && time.Since(lastFailure) > p.ResetAfter {
+		return time.Time{}
+	}
+
+	if p.MaxAttempts > 0 && failures >= p.MaxAttempts {
+		return lastFailure.Add(p.LockoutDuration)
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			delay = p.MaxDelay
+			break
+		}
+	}
+
+	return lastFailure.Add(delay)
+}
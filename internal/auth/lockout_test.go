@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyBlockedUntil(t *testing.T) {
+	policy := LockoutPolicy{
+		FreeAttempts:    3,
+		MaxAttempts:     10,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutDuration: 15 * time.Minute,
+		ResetAfter:      24 * time.Hour,
+	}
+	last := time.Now()
+
+	tests := []struct {
+		name        string
+		failures    int
+		lastFailure time.Time
+		wantDelay   time.Duration
+	}{
+		{name: "No failures", failures: 0, lastFailure: last, wantDelay: 0},
+		{name: "Free attempts", failures: 2, lastFailure: last, wantDelay: 0},
+		{name: "First backoff", failures: 3, lastFailure: last, wantDelay: time.Second},
+		{name: "Backoff doubles", failures: 5, lastFailure: last, wantDelay: 4 * time.Second},
+		{name: "Backoff is capped", failures: 9, lastFailure: last, wantDelay: time.Minute},
+		{name: "Lockout", failures: 10, lastFailure: last, wantDelay: 15 * time.Minute},
+		{name: "Old failures are forgotten", failures: 10, lastFailure: last.Add(-25 * time.Hour), wantDelay: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.BlockedUntil(tt.failures, tt.lastFailure)
+			if tt.wantDelay == 0 {
+				if !got.IsZero() {
+					t.Errorf("BlockedUntil() = %v, want no block", got)
+				}
+				return
+			}
+			if want := tt.lastFailure.Add(tt.wantDelay); !got.Equal(want) {
+				t.Errorf("BlockedUntil() = %v, want %v", got.Sub(tt.lastFailure), tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestLockoutPolicyWithoutMaxAttempts(t *testing.T) {
+	policy := LockoutPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     10 * time.Minute,
+		ResetAfter:   24 * time.Hour,
+	}
+	last := time.Now()
+
+	if got := policy.BlockedUntil(1000, last); !got.Equal(last.Add(10 * time.Minute)) {
+		t.Errorf("BlockedUntil() = %v, want the capped delay of %v", got.Sub(last), 10*time.Minute)
+	}
+}
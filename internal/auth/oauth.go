@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// PKCEMethodS256 is the only code_challenge_method accepted, "plain"
+// would let anyone who sees the authorization request redeem the code
+const PKCEMethodS256 = "S256"
+
+// OAuthScopes are the scopes a third-party app can be granted. Managing
+// the account itself (users:write) is left to first-party logins.
+var OAuthScopes = []string{ScopeChirpsWrite, ScopeUsersRead}
+
+// ParseOAuthScopes is ParseScopes for OAuth apps, rejecting any scope
+// outside OAuthScopes
+func ParseOAuthScopes(scope string) ([]string, error) {
+	requested, err := ParseScopes(scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range requested {
+		if !containsScope(OAuthScopes, s) {
+			return nil, fmt.Errorf("scope %q can't be granted to OAuth apps", s)
+		}
+	}
+	return requested, nil
+}
+
+// FilterOAuthScopes drops whatever OAuthScopes doesn't allow, for grants
+// stored before the split
+func FilterOAuthScopes(scopes []string) []string {
+	filtered := []string{}
+	for _, s := range scopes {
+		if containsScope(OAuthScopes, s) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// MakeOAuthClientID returns a new public identifier for an OAuth client
+func MakeOAuthClientID() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// PKCEChallenge derives the S256 code_challenge for a code_verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE checks a code_verifier against the S256 code_challenge sent
+// with the authorization request (RFC 7636)
+func VerifyPKCE(verifier, challenge string) bool {
+	// 43 to 128 characters from the unreserved set, see RFC 7636 4.1
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		unreserved := c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~'
+		if !unreserved {
+			return false
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// ValidateRedirectURI checks a redirect URI at client registration. It must
+// be absolute, without a fragment, and use https unless it points at the
+// local machine (for native apps and development).
+func ValidateRedirectURI(rawURI string) error {
+	u, err := url.Parse(rawURI)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return errors.New("redirect URI must be absolute")
+	}
+	if u.Fragment != "" {
+		return errors.New("redirect URI must not have a fragment")
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return nil
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+		return errors.New("redirect URI must use https")
+	default:
+		return errors.New("redirect URI must use https")
+	}
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 Appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := PKCEChallenge(verifier); got != challenge {
+		t.Fatalf("PKCEChallenge() = %q, want %q", got, challenge)
+	}
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{name: "Matching verifier", verifier: verifier, challenge: challenge, want: true},
+		{name: "Wrong verifier", verifier: strings.Repeat("a", 43), challenge: challenge, want: false},
+		{name: "Plain method", verifier: verifier, challenge: verifier, want: false},
+		{name: "Too short", verifier: "short", challenge: PKCEChallenge("short"), want: false},
+		{name: "Too long", verifier: strings.Repeat("a", 129), challenge: PKCEChallenge(strings.Repeat("a", 129)), want: false},
+		{name: "Reserved characters", verifier: strings.Repeat("a", 42) + "/", challenge: PKCEChallenge(strings.Repeat("a", 42) + "/"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{uri: "https://app.example.com/callback", wantErr: false},
+		{uri: "http://localhost:3000/callback", wantErr: false},
+		{uri: "http://127.0.0.1:8000/cb", wantErr: false},
+		{uri: "http://app.example.com/callback", wantErr: true},
+		{uri: "https://app.example.com/callback#frag", wantErr: true},
+		{uri: "/callback", wantErr: true},
+		{uri: "javascript:alert(1)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			err := ValidateRedirectURI(tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRedirectURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseOAuthScopes(t *testing.T) {
+	scopes, err := ParseOAuthScopes("chirps:write users:read")
+	if err != nil || len(scopes) != 2 {
+		t.Errorf("ParseOAuthScopes() = %v, %v", scopes, err)
+	}
+	if _, err := ParseOAuthScopes("chirps:write users:write"); err == nil {
+		t.Errorf("ParseOAuthScopes() granted users:write to an app")
+	}
+
+	if got := FilterOAuthScopes(DefaultScopes); len(got) != len(OAuthScopes) {
+		t.Errorf("FilterOAuthScopes(%v) = %v, want %v", DefaultScopes, got, OAuthScopes)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/x6Nenko/Chirpy/internal/bloom"
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordIsEmail  = errors.New("password must not be your email address")
+	ErrPasswordBreached = errors.New("password appears in a list of breached passwords, choose another one")
+)
+
+// PasswordPolicy is checked before a password is hashed. MaxLength is in
+// bytes because that's what bounds the argon2id work per request.
+type PasswordPolicy struct {
+	MinLength int // in characters
+	MaxLength int // in bytes
+	Breached  *bloom.Filter
+}
+
+// Validate returns nil if the password is acceptable for this email
+func (p PasswordPolicy) Validate(password, email string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrPasswordTooShort, p.MinLength)
+	}
+	err := p.CheckLength(password)
+	if err != nil {
+		return err
+	}
+
+	lowerPassword := strings.ToLower(password)
+	lowerEmail := strings.ToLower(strings.TrimSpace(email))
+	if lowerEmail != "" {
+		localPart, _, _ := strings.Cut(lowerEmail, "@")
+		if lowerPassword == lowerEmail || lowerPassword == localPart {
+			return ErrPasswordIsEmail
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Test(password) {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
+
+// CheckLength only enforces MaxLength, for passwords that are about to be
+// checked against a hash rather than set. Nothing longer was ever
+// accepted, so it can be refused before paying for argon2id.
+func (p PasswordPolicy) CheckLength(password string) error {
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Errorf("%w, use at most %d bytes", ErrPasswordTooLong, p.MaxLength)
+	}
+	return nil
+}
+
+// LoadBreachedPasswords builds a bloom filter from a file with one
+// password per line. The file is read twice, once to size the filter and
+// once to fill it, so large corpora never have to sit in memory.
+func LoadBreachedPasswords(path string, falsePositiveRate float64) (*bloom.Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	count := 0
+	err = eachLine(file, func(string) { count++ })
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bloom.New(count, falsePositiveRate)
+	err = eachLine(file, filter.Add)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func eachLine(r io.Reader, fn func(string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		fn(line)
+	}
+	return scanner.Err()
+}
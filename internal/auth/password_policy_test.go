@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	dir := t.TempDir()
+	corpus := filepath.Join(dir, "breached.txt")
+	os.WriteFile(corpus, []byte("password123\nqwertyuiop\r\n\nletmein123\n"), 0600)
+
+	breached, err := LoadBreachedPasswords(corpus, 0.001)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords() error = %v", err)
+	}
+	if breached.Len() != 3 {
+		t.Fatalf("LoadBreachedPasswords() loaded %d passwords, want 3", breached.Len())
+	}
+
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 64, Breached: breached}
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		wantErr  error
+	}{
+		{name: "Good password", password: "correct horse battery", email: "user@example.com", wantErr: nil},
+		{name: "Empty", password: "", email: "user@example.com", wantErr: ErrPasswordTooShort},
+		{name: "Too short", password: "short", email: "user@example.com", wantErr: ErrPasswordTooShort},
+		{name: "Multibyte counts as characters", password: "ééééééé", email: "user@example.com", wantErr: ErrPasswordTooShort},
+		{name: "Too long", password: strings.Repeat("a", 65), email: "user@example.com", wantErr: ErrPasswordTooLong},
+		{name: "Email as password", password: "User@Example.com", email: "user@example.com", wantErr: ErrPasswordIsEmail},
+		{name: "Local part as password", password: "longusername", email: "longusername@example.com", wantErr: ErrPasswordIsEmail},
+		{name: "Breached", password: "password123", email: "user@example.com", wantErr: ErrPasswordBreached},
+		{name: "Breached with CRLF corpus", password: "qwertyuiop", email: "user@example.com", wantErr: ErrPasswordBreached},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password, tt.email)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := policy.CheckLength("short"); err != nil {
+		t.Errorf("CheckLength() error = %v for a short password", err)
+	}
+	if err := policy.CheckLength(strings.Repeat("a", 65)); !errors.Is(err, ErrPasswordTooLong) {
+		t.Errorf("CheckLength() error = %v, want %v", err, ErrPasswordTooLong)
+	}
+}
@@ -0,0 +1,32 @@
+package auth
+
+// Roles, each one can do everything the ones before it can
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants everything min does. Unknown
+// roles, including an empty one, grant nothing.
+func RoleAtLeast(role, min string) bool {
+	rank, ok := roleRank[role]
+	return ok && rank >= roleRank[min]
+}
+
+// HasRole reports whether the token's role is min or above
+func (c *Claims) HasRole(min string) bool {
+	return RoleAtLeast(c.Role, min)
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role string
+		min  string
+		want bool
+	}{
+		{role: RoleAdmin, min: RoleAdmin, want: true},
+		{role: RoleAdmin, min: RoleModerator, want: true},
+		{role: RoleModerator, min: RoleAdmin, want: false},
+		{role: RoleModerator, min: RoleUser, want: true},
+		{role: RoleUser, min: RoleModerator, want: false},
+		{role: "", min: RoleUser, want: false},
+		{role: "root", min: RoleUser, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.role+">="+tt.min, func(t *testing.T) {
+			if got := RoleAtLeast(tt.role, tt.min); got != tt.want {
+				t.Errorf("RoleAtLeast(%q, %q) = %v, want %v", tt.role, tt.min, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeJWTRole(t *testing.T) {
+	keys := NewHMACKeyRing("secret")
+
+	token, _ := MakeJWT(AccessToken{UserID: uuid.New(), Role: RoleModerator, Scopes: DefaultScopes}, keys, time.Hour)
+	claims, err := ValidateJWT(token, keys)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if claims.Role != RoleModerator {
+		t.Errorf("ValidateJWT() role = %q, want %q", claims.Role, RoleModerator)
+	}
+	if !claims.HasRole(RoleUser) || claims.HasRole(RoleAdmin) {
+		t.Errorf("HasRole() doesn't follow the role order for %q", claims.Role)
+	}
+}
+
+func TestDefaultScopesForRole(t *testing.T) {
+	hasAdmin := func(scopes []string) bool {
+		claims := Claims{Scope: strings.Join(scopes, " ")}
+		return claims.HasScope(ScopeAdmin)
+	}
+
+	if hasAdmin(DefaultScopesForRole(RoleUser)) {
+		t.Errorf("DefaultScopesForRole(%q) includes %q", RoleUser, ScopeAdmin)
+	}
+	if !hasAdmin(DefaultScopesForRole(RoleModerator)) || !hasAdmin(DefaultScopesForRole(RoleAdmin)) {
+		t.Errorf("DefaultScopesForRole() leaves %q out for moderators and admins", ScopeAdmin)
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Base32 of the RFC 6238 SHA1 test seed "12345678901234567890"
+const rfcTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B vectors, truncated to 6 digits
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "T=59", unix: 59, want: "287082"},
+		{name: "T=1111111109", unix: 1111111109, want: "081804"},
+		{name: "T=1234567890", unix: 1234567890, want: "005924"},
+		{name: "T=2000000000", unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TOTPCode(rfcTestSecret, TOTPStep(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatalf("TOTPCode() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("TOTPCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, _ := GenerateTOTPSecret()
+	now := time.Now()
+	code, _ := TOTPCode(secret, TOTPStep(now))
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+
+	tests := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{name: "Current code", code: code, wantOK: true},
+		{name: "Previous step is tolerated", code: previous, wantOK: true},
+		{name: "Wrong length", code: "12345", wantOK: false},
+		{name: "Empty", code: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := ValidateTOTP(secret, tt.code, now)
+			if ok != tt.wantOK {
+				t.Errorf("ValidateTOTP() = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Chirpy", "user@example.com", rfcTestSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:user@example.com?") {
+		t.Errorf("TOTPURI() = %v, unexpected label", uri)
+	}
+	if !strings.Contains(uri, "secret="+rfcTestSecret) {
+		t.Errorf("TOTPURI() = %v, missing secret", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("GenerateRecoveryCodes() returned %d codes, want 10", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("GenerateRecoveryCodes() returned duplicate %v", code)
+		}
+		seen[code] = true
+	}
+
+	messy := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if NormalizeRecoveryCode(messy) != codes[0] {
+		t.Errorf("NormalizeRecoveryCode(%q) = %v, want %v", messy, NormalizeRecoveryCode(messy), codes[0])
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	keys := NewHMACKeyRing("secret")
+	userID := uuid.New()
+
+	mfaToken, _ := MakeMFAToken(userID, keys, time.Minute, DefaultScopes)
+	if _, err := ValidateJWT(mfaToken, keys); err == nil {
+		t.Errorf("ValidateJWT() accepted an MFA token")
+	}
+
+	claims, err := ValidateMFAToken(mfaToken, keys)
+	if err != nil || claims.UserID != userID {
+		t.Errorf("ValidateMFAToken() = %v, %v", claims, err)
+	}
+
+	accessToken, _ := MakeJWT(AccessToken{UserID: userID, Role: RoleUser, Scopes: DefaultScopes}, keys, time.Minute)
+	if _, err := ValidateMFAToken(accessToken, keys); err == nil {
+		t.Errorf("ValidateMFAToken() accepted an access token")
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"user.upgraded"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	current := WebhookSignaturePrefix + SignWebhook("current", now.Unix(), body)
+	previous := WebhookSignaturePrefix + SignWebhook("previous", now.Unix(), body)
+	secrets := []string{"current", "previous"}
+
+	tests := []struct {
+		name      string
+		secrets   []string
+		timestamp string
+		signature string
+		body      []byte
+		now       time.Time
+		wantErr   error
+	}{
+		{name: "Current secret", secrets: secrets, timestamp: timestamp, signature: current, body: body, now: now},
+		{name: "Previous secret", secrets: secrets, timestamp: timestamp, signature: previous, body: body, now: now},
+		{name: "Both signatures", secrets: []string{"previous"}, timestamp: timestamp, signature: current + ", " + previous, body: body, now: now},
+		{name: "Retired secret", secrets: []string{"current"}, timestamp: timestamp, signature: previous, body: body, now: now, wantErr: ErrWebhookSignature},
+		{name: "Tampered body", secrets: secrets, timestamp: timestamp, signature: current, body: []byte(`{"event":"user.downgraded"}`), now: now, wantErr: ErrWebhookSignature},
+		{name: "Different timestamp", secrets: secrets, timestamp: strconv.FormatInt(now.Unix()-1, 10), signature: current, body: body, now: now, wantErr: ErrWebhookSignature},
+		{name: "Missing prefix", secrets: secrets, timestamp: timestamp, signature: SignWebhook("current", now.Unix(), body), body: body, now: now, wantErr: ErrWebhookSignature},
+		{name: "Inside tolerance", secrets: secrets, timestamp: timestamp, signature: current, body: body, now: now.Add(4 * time.Minute)},
+		{name: "Too old", secrets: secrets, timestamp: timestamp, signature: current, body: body, now: now.Add(6 * time.Minute), wantErr: ErrWebhookTimestamp},
+		{name: "From the future", secrets: secrets, timestamp: timestamp, signature: current, body: body, now: now.Add(-6 * time.Minute), wantErr: ErrWebhookTimestamp},
+		{name: "Missing timestamp", secrets: secrets, timestamp: "", signature: current, body: body, now: now, wantErr: ErrWebhookTimestamp},
+		{name: "Empty secret", secrets: []string{""}, timestamp: timestamp, signature: WebhookSignaturePrefix + SignWebhook("", now.Unix(), body), body: body, now: now, wantErr: ErrWebhookSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhookSignature(tt.secrets, tt.timestamp, tt.signature, tt.body, tt.now, 5*time.Minute)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyWebhookSignature() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
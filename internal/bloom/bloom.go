@@ -0,0 +1,80 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Filter is a Bloom filter: Test never misses an added item, but may
+// report items that were never added with roughly the configured
+// false positive rate
+type Filter struct {
+	bits   []uint64
+	m      uint64 // number of bits
+	k      uint64 // number of hash functions
+	length int
+}
+
+// New sizes a filter for n items at false positive rate p (e.g. 0.001)
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.001
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// hashes derives two independent hashes, the k probe positions are
+// h1 + i*h2 (Kirsch-Mitzenmacher double hashing)
+func hashes(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(item))
+	sum := h.Sum(nil)
+
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+	return h1, h2 | 1 // an odd step visits more distinct positions
+}
+
+// Add inserts an item
+func (f *Filter) Add(item string) {
+	h1, h2 := hashes(item)
+	for i := uint64(0); i < f.k; i++ {
+		pos := (h1 + i*h2) % f.m
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+	f.length++
+}
+
+// Test reports whether the item may have been added
+func (f *Filter) Test(item string) bool {
+	h1, h2 := hashes(item)
+	for i := uint64(0); i < f.k; i++ {
+		pos := (h1 + i*h2) % f.m
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns how many items were added
+func (f *Filter) Len() int {
+	return f.length
+}
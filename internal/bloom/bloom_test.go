@@ -0,0 +1,46 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilterHasNoFalseNegatives(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("password%d", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		item := fmt.Sprintf("password%d", i)
+		if !f.Test(item) {
+			t.Fatalf("Test(%q) = false for an added item", item)
+		}
+	}
+
+	if f.Len() != 1000 {
+		t.Errorf("Len() = %d, want 1000", f.Len())
+	}
+}
+
+func TestFilterFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	const p = 0.01
+
+	f := New(n, p)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("added-%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.Test(fmt.Sprintf("missing-%d", i)) {
+			falsePositives++
+		}
+	}
+
+	// Allow generous slack over the target rate
+	if rate := float64(falsePositives) / n; rate > p*3 {
+		t.Errorf("false positive rate = %v, want around %v", rate, p)
+	}
+}
@@ -11,28 +11,200 @@ import (
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scope      string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type AuditEvent struct {
+	ID         int64
+	OccurredAt time.Time
+	ActorID    uuid.NullUUID
+	Action     string
+	TargetType string
+	TargetID   string
+	IpAddress  string
+	RequestID  string
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Body      string
 	UserID    uuid.UUID
+	ReplyTo   uuid.NullUUID
 }
 
-type RefreshToken struct {
-	Token     string
+type EmailVerificationToken struct {
+	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
+	UserID    uuid.UUID
+	Email     string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type LoginFailure struct {
+	Kind          string
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           string
+	CreatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris string
+	Scope        string
+}
+
+type Outbox struct {
+	ID            int64
+	EventID       uuid.UUID
+	EventType     string
+	AggregateType string
+	AggregateID   string
+	Payload       string
+	OccurredAt    time.Time
+	PublishedAt   sql.NullTime
+	PublishedTo   string
+	Attempts      int32
+	LastError     string
+	FailedAt      sql.NullTime
+}
+
+type PasswordResetToken struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	Token      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	ID         uuid.UUID
+	UserAgent  string
+	IpAddress  string
+	LastUsedAt time.Time
+	Scope      string
+	ClientID   sql.NullString
+}
+
+type RevokedAccessToken struct {
+	Jti       string
 	UserID    uuid.UUID
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	RevokedAt time.Time
+}
+
+type Subscription struct {
+	ID               uuid.UUID
+	UserID           uuid.UUID
+	Plan             string
+	Status           string
+	CurrentPeriodEnd sql.NullTime
+	GracePeriodEnd   sql.NullTime
+	CanceledAt       sql.NullTime
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	LastEventAt      sql.NullTime
 }
 
 type User struct {
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	HashedPassword  string
+	IsChirpyRed     bool
+	EmailVerifiedAt sql.NullTime
+	TotpSecret      sql.NullString
+	TotpEnabledAt   sql.NullTime
+	TotpLastStep    int64
+	DeleteAfter     sql.NullTime
+	Role            string
+	SuspendedAt     sql.NullTime
+}
+
+type WebhookEvent struct {
 	ID             uuid.UUID
-	CreatedAt      time.Time
+	Provider       string
+	EventID        string
+	EventType      string
+	Payload        string
+	Status         string
+	ResponseStatus int32
+	Error          string
+	Attempts       int32
+	ReceivedAt     time.Time
+	ProcessedAt    sql.NullTime
 	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	SubscriptionID uuid.UUID
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int32
+	ResponseStatus int32
+	LastError      string
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	DeliveredAt    sql.NullTime
+	EventID        uuid.NullUUID
+}
+
+type WebhookSubscription struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	UserID       uuid.UUID
+	Url          string
+	Events       string
+	Secret       string
+	FailureCount int32
+	DisabledAt   sql.NullTime
 }
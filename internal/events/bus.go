@@ -0,0 +1,47 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Handler reacts to an event. Returning an error has the event published
+// again later, so handlers have to cope with seeing it twice.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus hands events to handlers in the same process
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[string][]Handler{}}
+}
+
+// Subscribe calls handler for events of eventType, "*" for every event
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish runs the handlers one after another, all of them even if some
+// fail
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler(nil), b.handlers[event.Type]...), b.handlers["*"]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		err := handler(ctx, event)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("handling %s: %w", event.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,26 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a domain event, something that changed in Chirpy's state
+type Event struct {
+	ID            uuid.UUID       `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Sink is somewhere events are published to. Publish must only return nil
+// once the event is safely handed over, it is called again otherwise, so
+// sinks see every event at least once and sometimes more than once.
+type Sink interface {
+	Publish(ctx context.Context, event Event) error
+}
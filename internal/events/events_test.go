@@ -0,0 +1,185 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testEvent(eventType string) Event {
+	return Event{
+		ID:            uuid.New(),
+		Type:          eventType,
+		AggregateType: "chirp",
+		AggregateID:   uuid.NewString(),
+		OccurredAt:    time.Now().UTC(),
+		Data:          json.RawMessage(`{"body":"hello"}`),
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	var got []string
+	bus.Subscribe("chirp.created", func(ctx context.Context, event Event) error {
+		got = append(got, "created:"+event.Type)
+		return nil
+	})
+	bus.Subscribe("*", func(ctx context.Context, event Event) error {
+		got = append(got, "all:"+event.Type)
+		return nil
+	})
+
+	for _, eventType := range []string{"chirp.created", "chirp.deleted"} {
+		if err := bus.Publish(context.Background(), testEvent(eventType)); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	want := []string{"created:chirp.created", "all:chirp.created", "all:chirp.deleted"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("handlers saw %v, want %v", got, want)
+	}
+}
+
+func TestBusHandlerError(t *testing.T) {
+	bus := NewBus()
+	failing := errors.New("boom")
+	ran := false
+	bus.Subscribe("*", func(ctx context.Context, event Event) error { return failing })
+	bus.Subscribe("*", func(ctx context.Context, event Event) error {
+		ran = true
+		return nil
+	})
+
+	err := bus.Publish(context.Background(), testEvent("chirp.created"))
+	if !errors.Is(err, failing) {
+		t.Errorf("Publish() error = %v, want %v", err, failing)
+	}
+	if !ran {
+		t.Error("a failing handler kept the next one from running")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink := &FileSink{Path: path}
+
+	first, second := testEvent("chirp.created"), testEvent("chirp.deleted")
+	for _, event := range []Event{first, second} {
+		if err := sink.Publish(context.Background(), event); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var ids []uuid.UUID
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q isn't an event: %v", scanner.Text(), err)
+		}
+		ids = append(ids, event.ID)
+	}
+	if len(ids) != 2 || ids[0] != first.ID || ids[1] != second.ID {
+		t.Errorf("file has events %v, want %v and %v", ids, first.ID, second.ID)
+	}
+}
+
+// fakeNATS accepts one connection, speaks enough of the protocol for a
+// publisher and sends every PUB payload to msgs
+func fakeNATS(t *testing.T, msgs chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"fake\"}\r\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case line == "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case strings.HasPrefix(line, "PUB "):
+				var subject string
+				var size int
+				fmt.Sscanf(line, "PUB %s %d", &subject, &size)
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				msgs <- subject + " " + string(payload[:size])
+			}
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestNATSSink(t *testing.T) {
+	msgs := make(chan string, 2)
+	sink := &NATSSink{Addr: fakeNATS(t, msgs), Prefix: "chirpy", Timeout: time.Second}
+	defer sink.Close()
+
+	event := testEvent("chirp.created")
+	for range 2 {
+		if err := sink.Publish(context.Background(), event); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	for range 2 {
+		msg := <-msgs
+		subject, payload, _ := strings.Cut(msg, " ")
+		if subject != "chirpy.chirp.created" {
+			t.Errorf("published on %q, want chirpy.chirp.created", subject)
+		}
+		var got Event
+		if err := json.Unmarshal([]byte(payload), &got); err != nil || got.ID != event.ID {
+			t.Errorf("published %q, want event %s", payload, event.ID)
+		}
+	}
+}
+
+func TestNATSSinkUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	sink := &NATSSink{Addr: addr, Timeout: 100 * time.Millisecond}
+	if err := sink.Publish(context.Background(), testEvent("chirp.created")); err == nil {
+		t.Error("Publish() error = nil with no server")
+	}
+}
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSSink publishes events to a NATS server (or anything speaking its
+// text protocol) on the subject "<Prefix>.<event type>". Each publish is
+// followed by a PING, the server's PONG confirms it has processed the
+// PUB. There's no JetStream acknowledgement, so events reach only the
+// subscribers connected at the time.
+type NATSSink struct {
+	Addr    string // host:port
+	Prefix  string
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (s *NATSSink) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	subject := event.Type
+	if s.Prefix != "" {
+		subject = s.Prefix + "." + event.Type
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.publish(ctx, subject, payload)
+	if err != nil {
+		// Start over on a fresh connection next time
+		s.close()
+	}
+	return err
+}
+
+// Close drops the connection to the server
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.close()
+}
+
+func (s *NATSSink) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	s.reader = nil
+	return err
+}
+
+func (s *NATSSink) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return 5 * time.Second
+}
+
+func (s *NATSSink) publish(ctx context.Context, subject string, payload []byte) error {
+	if s.conn == nil {
+		err := s.connect(ctx)
+		if err != nil {
+			return fmt.Errorf("connecting to NATS: %w", err)
+		}
+	}
+
+	deadline := time.Now().Add(s.timeout())
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	s.conn.SetDeadline(deadline)
+
+	_, err := fmt.Fprintf(s.conn, "PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)
+	if err != nil {
+		return err
+	}
+	return s.waitForPong()
+}
+
+func (s *NATSSink) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: s.timeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	// The server greets with INFO before anything else
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+
+	_, err = fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"chirpy\"}\r\nPING\r\n")
+	if err != nil {
+		return err
+	}
+	return s.waitForPong()
+}
+
+// waitForPong reads until the server answers our PING, answering its own
+// PINGs and failing on -ERR
+func (s *NATSSink) waitForPong() error {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			_, err = fmt.Fprint(s.conn, "PONG\r\n")
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS server: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (s *NATSSink) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
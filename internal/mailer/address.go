@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// ValidateAddress checks that s is a bare email address like
+// "user@example.com" (no display name, no angle brackets) with a domain
+// that at least looks routable
+func ValidateAddress(s string) error {
+	if len(s) > 254 {
+		return errors.New("email address is too long")
+	}
+
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return errors.New("invalid email address")
+	}
+	if addr.Address != s || addr.Name != "" {
+		return errors.New("email address must not include a name")
+	}
+
+	at := strings.LastIndex(s, "@")
+	domain := s[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return errors.New("invalid email domain")
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConsoleMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewConsoleMailer("noreply@chirpy.test", &buf)
+
+	err := m.Send(context.Background(), Message{
+		To:      "user@example.com",
+		Subject: "Hello",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"To: user@example.com", "Subject: Hello", "line two"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Send() output missing %q", want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "noreply@chirpy.test"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "../evil/user@example.com",
+		Subject: "Reset",
+		Body:    "token",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Send() wrote %d files, want 1", len(files))
+	}
+
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "Subject: Reset") {
+		t.Errorf("saved email is missing the subject")
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	m.Send(context.Background(), Message{To: "a@example.com", Subject: "first"})
+	m.Send(context.Background(), Message{To: "b@example.com", Subject: "other"})
+	m.Send(context.Background(), Message{To: "a@example.com", Subject: "second"})
+
+	if got := len(m.Messages()); got != 3 {
+		t.Errorf("Messages() has %d emails, want 3", got)
+	}
+
+	last, ok := m.Last("a@example.com")
+	if !ok || last.Subject != "second" {
+		t.Errorf("Last() = %v, %v, want the second email", last, ok)
+	}
+
+	if _, ok := m.Last("nobody@example.com"); ok {
+		t.Errorf("Last() found an email that was never sent")
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		wantErr bool
+	}{
+		{name: "Valid", address: "user@example.com", wantErr: false},
+		{name: "Plus addressing", address: "user+chirpy@mail.example.co.uk", wantErr: false},
+		{name: "Empty", address: "", wantErr: true},
+		{name: "No at sign", address: "user.example.com", wantErr: true},
+		{name: "Display name", address: "User <user@example.com>", wantErr: true},
+		{name: "No dot in domain", address: "user@localhost", wantErr: true},
+		{name: "Trailing dot", address: "user@example.", wantErr: true},
+		{name: "Spaces", address: "us er@example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAddress(tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAddress(%q) error = %v, wantErr %v", tt.address, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Changes a provider's event can make to a subscription
+const (
+	SubscriptionActivated     = "activated"
+	SubscriptionPaymentFailed = "payment_failed"
+	SubscriptionCanceled      = "canceled"
+	SubscriptionEnded         = "ended"
+)
+
+// States a subscription can be in
+const (
+	StatusActive   = "active"
+	StatusPastDue  = "past_due"
+	StatusCanceled = "canceled"
+	StatusExpired  = "expired"
+)
+
+// ErrUnauthenticated is returned by Authenticate for deliveries that
+// can't be shown to come from the provider
+var ErrUnauthenticated = errors.New("webhook couldn't be authenticated")
+
+// Event is a provider's webhook payload decoded into common fields
+type Event struct {
+	// ID is the provider's event ID, deliveries with the same ID are the
+	// same event. Empty if the provider didn't send one.
+	ID   string
+	Type string
+
+	UserID           uuid.UUID
+	Plan             string
+	CurrentPeriodEnd *time.Time
+	// OccurredAt is when the provider says the event happened, nil if the
+	// payload doesn't say
+	OccurredAt *time.Time
+}
+
+// SubscriptionChange is what an event does to a user's subscription
+type SubscriptionChange struct {
+	Kind   string
+	UserID uuid.UUID
+	Plan   string
+	// CurrentPeriodEnd is when the paid period ends, nil for no end date
+	CurrentPeriodEnd *time.Time
+	// OccurredAt orders changes, see Applies
+	OccurredAt time.Time
+}
+
+// SubscriptionState is what a change is checked against
+type SubscriptionState struct {
+	// Status is one of the Status* states, "" without a subscription
+	Status string
+	// LastChangeAt is when the last applied change happened, zero if none
+	// has been recorded
+	LastChangeAt time.Time
+}
+
+// Applies reports whether change moves a subscription in state. Providers
+// don't promise to deliver events in order, so a change that happened
+// before the last applied one is ignored: a late activation mustn't undo
+// a newer cancellation. Otherwise activating always applies, a failed
+// payment or a cancellation only to an active or past due subscription,
+// and ending to any subscription that hasn't ended.
+func (c SubscriptionChange) Applies(state SubscriptionState) bool {
+	if c.OccurredAt.Before(state.LastChangeAt) {
+		return false
+	}
+
+	switch c.Kind {
+	case SubscriptionActivated:
+		return true
+	case SubscriptionPaymentFailed, SubscriptionCanceled:
+		return state.Status == StatusActive || state.Status == StatusPastDue
+	case SubscriptionEnded:
+		return state.Status != "" && state.Status != StatusExpired
+	}
+	return false
+}
+
+// Provider is a payment provider sending us webhooks
+type Provider interface {
+	// Authenticate checks that a delivery really comes from the provider,
+	// body is the raw request body
+	Authenticate(r *http.Request, body []byte) error
+	// ParseEvent decodes a delivery. header is nil when an event is
+	// replayed from its stored payload.
+	ParseEvent(header http.Header, body []byte) (Event, error)
+	// SubscriptionChange maps an event to a subscription change, false for
+	// events that don't concern subscriptions
+	SubscriptionChange(event Event) (SubscriptionChange, bool)
+}
+
+// changeFor builds the SubscriptionChange of kind for an event. Without a
+// time in the event OccurredAt is left zero for the caller to fill in.
+func changeFor(kind string, event Event) SubscriptionChange {
+	change := SubscriptionChange{
+		Kind:             kind,
+		UserID:           event.UserID,
+		Plan:             event.Plan,
+		CurrentPeriodEnd: event.CurrentPeriodEnd,
+	}
+	if event.OccurredAt != nil {
+		change.OccurredAt = *event.OccurredAt
+	}
+	return change
+}
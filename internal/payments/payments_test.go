@@ -0,0 +1,233 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+)
+
+func signedRequest(body, timestampHeader, signatureHeader, secret string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/test", strings.NewReader(body))
+	timestamp := time.Now().Unix()
+	r.Header.Set(timestampHeader, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(signatureHeader, auth.WebhookSignaturePrefix+auth.SignWebhook(secret, timestamp, []byte(body)))
+	return r
+}
+
+func TestPolkaProvider(t *testing.T) {
+	userID := uuid.New()
+	body := `{"id": "evt_1", "event": "payment.failed", "data": {"user_id": "` + userID.String() + `", "plan": "chirpy_red"}}`
+
+	signed := &PolkaProvider{Secrets: []string{"secret"}, Tolerance: time.Minute}
+	if err := signed.Authenticate(signedRequest(body, "X-Polka-Timestamp", "X-Polka-Signature", "secret"), []byte(body)); err != nil {
+		t.Errorf("Authenticate() error = %v", err)
+	}
+	err := signed.Authenticate(signedRequest(body, "X-Polka-Timestamp", "X-Polka-Signature", "wrong"), []byte(body))
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Authenticate() with the wrong secret error = %v, want ErrUnauthenticated", err)
+	}
+
+	legacy := &PolkaProvider{APIKey: "key"}
+	r := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+	r.Header.Set("Authorization", "ApiKey key")
+	if err := legacy.Authenticate(r, []byte(body)); err != nil {
+		t.Errorf("Authenticate() with the API key error = %v", err)
+	}
+	r.Header.Set("Authorization", "ApiKey other")
+	if err := legacy.Authenticate(r, []byte(body)); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Authenticate() with the wrong API key error = %v, want ErrUnauthenticated", err)
+	}
+
+	// The header isn't signed, so it can't change the event's identity
+	header := http.Header{}
+	header.Set("X-Polka-Event-ID", "evt_2")
+	event, err := signed.ParseEvent(header, []byte(body))
+	if err != nil {
+		t.Fatalf("ParseEvent() error = %v", err)
+	}
+	if event.ID != "evt_1" || event.Type != "payment.failed" || event.UserID != userID || event.Plan != "chirpy_red" {
+		t.Errorf("ParseEvent() = %+v", event)
+	}
+
+	change, ok := signed.SubscriptionChange(event)
+	if !ok || change.Kind != SubscriptionPaymentFailed || change.UserID != userID {
+		t.Errorf("SubscriptionChange() = %+v, %v", change, ok)
+	}
+
+	event.Type = "user.created"
+	if _, ok := signed.SubscriptionChange(event); ok {
+		t.Error("SubscriptionChange() mapped an unrelated event")
+	}
+}
+
+func TestSignedJSONProvider(t *testing.T) {
+	userID := uuid.New()
+	body := `{"id": "evt_1", "type": "subscription.activated", "user_id": "` + userID.String() + `", "current_period_end": "2030-01-01T00:00:00Z"}`
+	p := &SignedJSONProvider{Secrets: []string{"new", "old"}, Tolerance: time.Minute}
+
+	if err := p.Authenticate(signedRequest(body, "X-Webhook-Timestamp", "X-Webhook-Signature", "old"), []byte(body)); err != nil {
+		t.Errorf("Authenticate() error = %v", err)
+	}
+	if err := (&SignedJSONProvider{}).Authenticate(signedRequest(body, "X-Webhook-Timestamp", "X-Webhook-Signature", ""), []byte(body)); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Authenticate() without secrets error = %v, want ErrUnauthenticated", err)
+	}
+
+	event, err := p.ParseEvent(nil, []byte(body))
+	if err != nil {
+		t.Fatalf("ParseEvent() error = %v", err)
+	}
+	change, ok := p.SubscriptionChange(event)
+	if !ok || change.Kind != SubscriptionActivated || change.UserID != userID {
+		t.Fatalf("SubscriptionChange() = %+v, %v", change, ok)
+	}
+	if change.CurrentPeriodEnd == nil || !change.CurrentPeriodEnd.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("CurrentPeriodEnd = %v", change.CurrentPeriodEnd)
+	}
+
+	for _, eventType := range []string{"subscription.upgraded", "activated", "invoice.paid"} {
+		event.Type = eventType
+		if _, ok := p.SubscriptionChange(event); ok {
+			t.Errorf("SubscriptionChange() mapped %q", eventType)
+		}
+	}
+
+	if _, err := p.ParseEvent(nil, []byte(`{"type": "subscription.ended"}`)); err == nil {
+		t.Error("ParseEvent() accepted an event without an id")
+	}
+}
+
+func TestSubscriptionChangeApplies(t *testing.T) {
+	canceledAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	earlier := canceledAt.Add(-time.Minute)
+	later := canceledAt.Add(time.Minute)
+
+	tests := []struct {
+		name   string
+		kind   string
+		at     time.Time
+		status string
+		lastAt time.Time
+		want   bool
+	}{
+		{name: "Activate without a subscription", kind: SubscriptionActivated, at: later, status: "", want: true},
+		{name: "Renew an active subscription", kind: SubscriptionActivated, at: later, status: StatusActive, lastAt: canceledAt, want: true},
+		{name: "Reactivate after a cancel", kind: SubscriptionActivated, at: later, status: StatusCanceled, lastAt: canceledAt, want: true},
+		{name: "Late activation after a cancel", kind: SubscriptionActivated, at: earlier, status: StatusCanceled, lastAt: canceledAt, want: false},
+		{name: "Late activation after it ended", kind: SubscriptionActivated, at: earlier, status: StatusExpired, lastAt: canceledAt, want: false},
+		{name: "Activate a subscription without recorded changes", kind: SubscriptionActivated, at: earlier, status: StatusExpired, want: true},
+		{name: "Same time as the last change", kind: SubscriptionCanceled, at: canceledAt, status: StatusActive, lastAt: canceledAt, want: true},
+		{name: "Payment fails while active", kind: SubscriptionPaymentFailed, at: later, status: StatusActive, want: true},
+		{name: "Payment fails again", kind: SubscriptionPaymentFailed, at: later, status: StatusPastDue, want: true},
+		{name: "Payment fails after a cancel", kind: SubscriptionPaymentFailed, at: later, status: StatusCanceled, want: false},
+		{name: "Payment fails without a subscription", kind: SubscriptionPaymentFailed, at: later, status: "", want: false},
+		{name: "Cancel while past due", kind: SubscriptionCanceled, at: later, status: StatusPastDue, want: true},
+		{name: "Cancel twice", kind: SubscriptionCanceled, at: later, status: StatusCanceled, want: false},
+		{name: "Late cancel after a renewal", kind: SubscriptionCanceled, at: earlier, status: StatusActive, lastAt: canceledAt, want: false},
+		{name: "End a canceled subscription", kind: SubscriptionEnded, at: later, status: StatusCanceled, want: true},
+		{name: "End an ended subscription", kind: SubscriptionEnded, at: later, status: StatusExpired, want: false},
+		{name: "End without a subscription", kind: SubscriptionEnded, at: later, status: "", want: false},
+		{name: "Unknown kind", kind: "paused", at: later, status: StatusActive, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := SubscriptionChange{Kind: tt.kind, UserID: uuid.New(), OccurredAt: tt.at}
+			got := change.Applies(SubscriptionState{Status: tt.status, LastChangeAt: tt.lastAt})
+			if got != tt.want {
+				t.Errorf("Applies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventTimeIsCarriedOver(t *testing.T) {
+	userID := uuid.New()
+	createdAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	polka := &PolkaProvider{}
+	event, err := polka.ParseEvent(nil, []byte(`{"id": "evt_1", "event": "user.upgraded", "created_at": "2025-03-01T12:00:00Z", "data": {"user_id": "`+userID.String()+`"}}`))
+	if err != nil {
+		t.Fatalf("ParseEvent() error = %v", err)
+	}
+	change, _ := polka.SubscriptionChange(event)
+	if !change.OccurredAt.Equal(createdAt) {
+		t.Errorf("OccurredAt = %v, want %v", change.OccurredAt, createdAt)
+	}
+
+	// Without a time it's left for the caller to fill in
+	event, _ = polka.ParseEvent(nil, []byte(`{"event": "user.upgraded", "data": {"user_id": "`+userID.String()+`"}}`))
+	if change, _ := polka.SubscriptionChange(event); !change.OccurredAt.IsZero() {
+		t.Errorf("OccurredAt = %v, want zero", change.OccurredAt)
+	}
+
+	signed := &SignedJSONProvider{}
+	event, err = signed.ParseEvent(nil, []byte(`{"id": "evt_2", "type": "subscription.canceled", "user_id": "`+userID.String()+`", "created_at": "2025-03-01T12:00:00Z"}`))
+	if err != nil {
+		t.Fatalf("ParseEvent() error = %v", err)
+	}
+	change, _ = signed.SubscriptionChange(event)
+	if !change.OccurredAt.Equal(createdAt) {
+		t.Errorf("OccurredAt = %v, want %v", change.OccurredAt, createdAt)
+	}
+}
+
+func TestPolkaUpgradeAfterDowngrade(t *testing.T) {
+	userID := uuid.New()
+	upgrade := `{"event": "user.upgraded", "data": {"user_id": "` + userID.String() + `"}}`
+	downgrade := `{"event": "user.downgraded", "data": {"user_id": "` + userID.String() + `"}}`
+
+	p := &PolkaProvider{Secrets: []string{"secret"}, Tolerance: time.Minute}
+	now := time.Now().Unix()
+	deliver := func(body string, timestamp int64) Event {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+		r.Header.Set("X-Polka-Timestamp", strconv.FormatInt(timestamp, 10))
+		r.Header.Set("X-Polka-Signature", auth.WebhookSignaturePrefix+auth.SignWebhook("secret", timestamp, []byte(body)))
+		if err := p.Authenticate(r, []byte(body)); err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+		event, err := p.ParseEvent(r.Header, []byte(body))
+		if err != nil {
+			t.Fatalf("ParseEvent() error = %v", err)
+		}
+		return event
+	}
+
+	first := deliver(upgrade, now-2)
+	down := deliver(downgrade, now-1)
+	second := deliver(upgrade, now)
+	if first.ID == "" || first.ID == second.ID {
+		t.Fatalf("upgrades got IDs %q and %q, want distinct ones", first.ID, second.ID)
+	}
+	// A retry of a delivery keeps its timestamp, so it's the same event
+	if retry := deliver(upgrade, now-2); retry.ID != first.ID {
+		t.Errorf("retry ID = %q, want %q", retry.ID, first.ID)
+	}
+
+	state := SubscriptionState{}
+	for i, event := range []Event{first, down, second} {
+		change, ok := p.SubscriptionChange(event)
+		if !ok {
+			t.Fatalf("SubscriptionChange(%s) not mapped", event.Type)
+		}
+		change.OccurredAt = time.Unix(now-2+int64(i), 0)
+		if !change.Applies(state) {
+			t.Fatalf("%s doesn't apply to %+v", event.Type, state)
+		}
+		state.LastChangeAt = change.OccurredAt
+		state.Status = StatusActive
+		if change.Kind == SubscriptionEnded {
+			state.Status = StatusExpired
+		}
+	}
+	if state.Status != StatusActive {
+		t.Errorf("status = %q, want %q", state.Status, StatusActive)
+	}
+}
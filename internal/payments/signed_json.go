@@ -0,0 +1,91 @@
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+)
+
+// signedJSONTypePrefix starts every event type a SignedJSONProvider acts
+// on, the rest is one of the Subscription* changes
+const signedJSONTypePrefix = "subscription."
+
+// SignedJSONProvider is for providers that can send our own webhook
+// format, so adding one needs configuration rather than code. Deliveries
+// carry X-Webhook-Timestamp and X-Webhook-Signature headers, signed like
+// Polka's, and a body such as
+//
+//	{"id": "evt_1", "type": "subscription.activated", "user_id": "...",
+//	 "plan": "chirpy_red", "current_period_end": "2025-01-01T00:00:00Z",
+//	 "created_at": "2024-12-01T00:00:00Z"}
+//
+// where type is "subscription." followed by activated, payment_failed,
+// canceled or ended, and created_at is when the event happened.
+type SignedJSONProvider struct {
+	Secrets   []string
+	Tolerance time.Duration
+}
+
+func (p *SignedJSONProvider) Authenticate(r *http.Request, body []byte) error {
+	if len(p.Secrets) == 0 {
+		return fmt.Errorf("%w: no signing secret configured", ErrUnauthenticated)
+	}
+
+	err := auth.VerifyWebhookSignature(
+		p.Secrets,
+		r.Header.Get("X-Webhook-Timestamp"),
+		r.Header.Get("X-Webhook-Signature"),
+		body,
+		time.Now(),
+		p.Tolerance,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+	return nil
+}
+
+func (p *SignedJSONProvider) ParseEvent(header http.Header, body []byte) (Event, error) {
+	var payload struct {
+		ID               string     `json:"id"`
+		Type             string     `json:"type"`
+		UserID           uuid.UUID  `json:"user_id"`
+		Plan             string     `json:"plan"`
+		CurrentPeriodEnd *time.Time `json:"current_period_end"`
+		CreatedAt        *time.Time `json:"created_at"`
+	}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return Event{}, err
+	}
+	if payload.ID == "" {
+		return Event{}, errors.New("event id is required")
+	}
+
+	return Event{
+		ID:               payload.ID,
+		Type:             payload.Type,
+		UserID:           payload.UserID,
+		Plan:             payload.Plan,
+		CurrentPeriodEnd: payload.CurrentPeriodEnd,
+		OccurredAt:       payload.CreatedAt,
+	}, nil
+}
+
+func (p *SignedJSONProvider) SubscriptionChange(event Event) (SubscriptionChange, bool) {
+	kind, ok := strings.CutPrefix(event.Type, signedJSONTypePrefix)
+	if !ok {
+		return SubscriptionChange{}, false
+	}
+	switch kind {
+	case SubscriptionActivated, SubscriptionPaymentFailed, SubscriptionCanceled, SubscriptionEnded:
+		return changeFor(kind, event), true
+	}
+	return SubscriptionChange{}, false
+}
@@ -0,0 +1,108 @@
+// Package stream fans events out to live connections, keeping the most
+// recent ones so a client that reconnects can catch up on what it missed.
+package stream
+
+import (
+	"sync"
+
+	"github.com/x6Nenko/Chirpy/internal/events"
+)
+
+// Hub hands every published event to all subscribers. Publishing never
+// blocks: a subscriber that falls more than its buffer behind is dropped
+// and its channel closed, and it can resume with the last ID it saw.
+type Hub struct {
+	mu          sync.Mutex
+	recent      []events.Event
+	next        int // where the next event goes in recent once it's full
+	size        int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives events on C until it is closed, either by
+// Unsubscribe or because the subscriber fell behind
+type Subscription struct {
+	C <-chan events.Event
+
+	c      chan events.Event
+	closed bool
+}
+
+// NewHub keeps the last replaySize events for resuming
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		size:        replaySize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish sends event to every subscriber. An event still in the replay
+// buffer has been sent before and is skipped, as events can arrive more
+// than once.
+func (h *Hub) Publish(event events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, seen := range h.recent {
+		if seen.ID == event.ID {
+			return
+		}
+	}
+
+	if h.size > 0 {
+		if len(h.recent) < h.size {
+			h.recent = append(h.recent, event)
+		} else {
+			h.recent[h.next] = event
+			h.next = (h.next + 1) % h.size
+		}
+	}
+
+	for sub := range h.subscribers {
+		select {
+		case sub.c <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// Subscribe starts receiving events. With a lastEventID it also returns the
+// events published after that one, if it's still in the replay buffer;
+// found reports whether it was. Nothing published in between is missed or
+// repeated.
+func (h *Hub) Subscribe(lastEventID string, bufferSize int) (sub *Subscription, replay []events.Event, found bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if lastEventID != "" {
+		ordered := append(append([]events.Event(nil), h.recent[h.next:]...), h.recent[:h.next]...)
+		for i, event := range ordered {
+			if event.ID.String() == lastEventID {
+				replay, found = ordered[i+1:], true
+				break
+			}
+		}
+	}
+
+	c := make(chan events.Event, bufferSize)
+	sub = &Subscription{C: c, c: c}
+	h.subscribers[sub] = struct{}{}
+	return sub, replay, found
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(sub)
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(h.subscribers, sub)
+	close(sub.c)
+}
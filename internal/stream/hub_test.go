@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/x6Nenko/Chirpy/internal/events"
+)
+
+func publishN(h *Hub, n int) []events.Event {
+	published := []events.Event{}
+	for i := 0; i < n; i++ {
+		event := events.Event{ID: uuid.New(), Type: "chirp.created"}
+		h.Publish(event)
+		published = append(published, event)
+	}
+	return published
+}
+
+func TestHubFanOut(t *testing.T) {
+	h := NewHub(10)
+	a, _, _ := h.Subscribe("", 10)
+	b, _, _ := h.Subscribe("", 10)
+
+	published := publishN(h, 3)
+	h.Publish(published[1])
+	for _, sub := range []*Subscription{a, b} {
+		for i, want := range published {
+			if got := <-sub.C; got.ID != want.ID {
+				t.Errorf("event %d = %s, want %s", i, got.ID, want.ID)
+			}
+		}
+	}
+
+	if len(a.C) != 0 {
+		t.Errorf("%d events left, a repeated event was sent again", len(a.C))
+	}
+
+	h.Unsubscribe(a)
+	if _, ok := <-a.C; ok {
+		t.Error("channel still open after Unsubscribe")
+	}
+	h.Unsubscribe(a)
+}
+
+func TestHubReplay(t *testing.T) {
+	h := NewHub(5)
+	published := publishN(h, 8)
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []events.Event
+		wantFound   bool
+	}{
+		{name: "No ID", lastEventID: "", want: nil, wantFound: false},
+		{name: "Latest", lastEventID: published[7].ID.String(), want: []events.Event{}, wantFound: true},
+		{name: "In buffer", lastEventID: published[4].ID.String(), want: published[5:], wantFound: true},
+		{name: "Oldest kept", lastEventID: published[3].ID.String(), want: published[4:], wantFound: true},
+		{name: "Fell out of buffer", lastEventID: published[2].ID.String(), want: nil, wantFound: false},
+		{name: "Unknown", lastEventID: uuid.NewString(), want: nil, wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, found := h.Subscribe(tt.lastEventID, 1)
+			defer h.Unsubscribe(sub)
+
+			if found != tt.wantFound {
+				t.Errorf("found = %v, want %v", found, tt.wantFound)
+			}
+			if len(replay) != len(tt.want) {
+				t.Fatalf("replayed %d events, want %d", len(replay), len(tt.want))
+			}
+			for i := range replay {
+				if replay[i].ID != tt.want[i].ID {
+					t.Errorf("replay[%d] = %s, want %s", i, replay[i].ID, tt.want[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	h := NewHub(0)
+	slow, _, _ := h.Subscribe("", 2)
+	fast, _, _ := h.Subscribe("", 10)
+
+	publishN(h, 3)
+
+	received := 0
+	for range slow.C {
+		received++
+	}
+	if received != 2 {
+		t.Errorf("slow subscriber got %d events before being dropped, want 2", received)
+	}
+	if len(fast.C) != 3 {
+		t.Errorf("fast subscriber has %d events, want 3", len(fast.C))
+	}
+}
@@ -0,0 +1,204 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/x6Nenko/Chirpy/internal/auth"
+)
+
+// Events users can subscribe to. There are no likes or follows yet, so no
+// chirp.liked or user.followed either.
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+)
+
+var events = map[string]bool{
+	EventChirpCreated: true,
+	EventChirpDeleted: true,
+}
+
+// ValidEvent reports whether event is one that can be subscribed to
+func ValidEvent(event string) bool {
+	return events[event]
+}
+
+// Headers sent with every delivery. The signature is made like the ones
+// on incoming webhooks, see auth.SignWebhook.
+const (
+	HeaderEvent     = "X-Chirpy-Event"
+	HeaderDelivery  = "X-Chirpy-Delivery"
+	HeaderTimestamp = "X-Chirpy-Timestamp"
+	HeaderSignature = "X-Chirpy-Signature"
+)
+
+const (
+	// MaxAttempts is how often a delivery is tried before giving up
+	MaxAttempts = 8
+
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+)
+
+// MakeSecret returns a new signing secret for a subscription
+func MakeSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(randomBytes), nil
+}
+
+// ErrPrivateAddress is returned for endpoints on the server's own network
+var ErrPrivateAddress = errors.New("webhook URL must not point at a private address")
+
+// publicAddr reports whether deliveries may go to addr. Loopback, private
+// (including IPv6 ULA), link-local, multicast and unspecified addresses
+// could reach services that trust the server's network.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!addr.IsUnspecified()
+}
+
+// ValidateURL checks an endpoint at registration. It must be absolute and
+// use https, and an IP address in it must be public. allowPrivate, meant
+// for development only, also lets plain http reach the local machine.
+// Host names are checked again when a delivery connects, see NewSender.
+func ValidateURL(rawURL string, allowPrivate bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return errors.New("webhook URL must be absolute")
+	}
+	if u.User != nil {
+		return errors.New("webhook URL must not contain credentials")
+	}
+
+	host := u.Hostname()
+	addr, err := netip.ParseAddr(host)
+	isIP := err == nil
+	local := host == "localhost" || (isIP && addr.Unmap().IsLoopback())
+	if !allowPrivate && (host == "localhost" || (isIP && !publicAddr(addr))) {
+		return ErrPrivateAddress
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if allowPrivate && local {
+			return nil
+		}
+	}
+	return errors.New("webhook URL must use https")
+}
+
+// Backoff is how long to wait before the next try after attempt failed
+// ones, doubling from 30 seconds up to 6 hours
+func Backoff(attempt int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// Delivery is one event on its way to one endpoint
+type Delivery struct {
+	ID      string
+	Event   string
+	Payload []byte
+}
+
+// Sender posts signed deliveries
+type Sender struct {
+	Client *http.Client
+}
+
+// NewSender returns a Sender with a timeout that doesn't follow
+// redirects, an endpoint can't bounce deliveries elsewhere. Unless
+// allowPrivate is set it refuses to connect to anything but a public
+// address, checked after DNS resolution so a host name can't be pointed
+// at the internal network (or rebound to it after registration).
+func NewSender(timeout time.Duration, allowPrivate bool) *Sender {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, address)
+			}
+			return nil
+		}
+	}
+
+	return &Sender{
+		Client: &http.Client{
+			Timeout: timeout,
+			// No proxy: the dialer has to see the endpoint's own address
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+				MaxIdleConns:        100,
+				IdleConnTimeout:     90 * time.Second,
+				ForceAttemptHTTP2:   true,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Send posts a delivery to endpoint. Anything but a 2xx response is an
+// error, the status is returned as well when there was a response.
+func (s *Sender) Send(ctx context.Context, endpoint, secret string, delivery Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, auth.WebhookSignaturePrefix+auth.SignWebhook(secret, timestamp, delivery.Payload))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
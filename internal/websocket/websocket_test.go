@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 section 1.3
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("AcceptKey() = %q", got)
+	}
+}
+
+// dial makes the opening handshake against a test server
+func dial(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n"+
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("reading handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", got)
+	}
+	return conn, reader
+}
+
+// writeClientFrame sends a masked frame, as clients have to
+func writeClientFrame(conn net.Conn, fin bool, opcode byte, payload []byte) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var extended [2]byte
+		io.ReadFull(reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestEcho(t *testing.T) {
+	serverErr := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			conn.WriteMessage(messageType, append([]byte("echo: "), data...), time.Now().Add(time.Second))
+		}
+	}))
+	defer srv.Close()
+
+	conn, reader := dial(t, srv.URL)
+
+	// A message in two fragments with a ping in between
+	writeClientFrame(conn, false, TextMessage, []byte("hel"))
+	writeClientFrame(conn, true, opPing, []byte("are you there"))
+	writeClientFrame(conn, true, opContinuation, []byte("lo"))
+
+	opcode, payload := readServerFrame(t, reader)
+	if opcode != opPong || string(payload) != "are you there" {
+		t.Errorf("got opcode %d %q, want the pong", opcode, payload)
+	}
+	opcode, payload = readServerFrame(t, reader)
+	if opcode != TextMessage || string(payload) != "echo: hello" {
+		t.Errorf("got opcode %d %q, want the echo", opcode, payload)
+	}
+
+	long := strings.Repeat("x", 300)
+	frame := []byte{0x80 | TextMessage, 0x80 | 126, 0x01, 0x2c, 0, 0, 0, 0}
+	conn.Write(append(frame, long...))
+	opcode, payload = readServerFrame(t, reader)
+	if opcode != TextMessage || string(payload) != "echo: "+long {
+		t.Errorf("got opcode %d with %d bytes, want the long echo", opcode, len(payload))
+	}
+
+	writeClientFrame(conn, true, opClose, binary.BigEndian.AppendUint16(nil, CloseGoingAway))
+	opcode, payload = readServerFrame(t, reader)
+	if opcode != opClose || binary.BigEndian.Uint16(payload) != CloseGoingAway {
+		t.Errorf("got opcode %d %v, want the close echoed", opcode, payload)
+	}
+
+	var closeErr *CloseError
+	if err := <-serverErr; !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway {
+		t.Errorf("ReadMessage() error = %v, want a CloseError with 1001", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		send     func(conn net.Conn)
+		wantCode int
+	}{
+		{
+			name: "Unmasked frame",
+			send: func(conn net.Conn) {
+				conn.Write([]byte{0x80 | TextMessage, 2, 'h', 'i'})
+			},
+			wantCode: CloseProtocolError,
+		},
+		{
+			name: "Invalid UTF-8",
+			send: func(conn net.Conn) {
+				writeClientFrame(conn, true, TextMessage, []byte{0xff, 0xfe})
+			},
+			wantCode: CloseInvalidPayload,
+		},
+		{
+			name: "Too big",
+			send: func(conn net.Conn) {
+				writeClientFrame(conn, true, TextMessage, []byte(strings.Repeat("x", 20)))
+			},
+			wantCode: CloseMessageTooBig,
+		},
+		{
+			name: "Stray continuation",
+			send: func(conn net.Conn) {
+				writeClientFrame(conn, true, opContinuation, []byte("x"))
+			},
+			wantCode: CloseProtocolError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := Upgrade(w, r)
+				if err != nil {
+					return
+				}
+				conn.MaxMessageSize = 16
+				conn.ReadMessage()
+			}))
+			defer srv.Close()
+
+			conn, reader := dial(t, srv.URL)
+			tt.send(conn)
+
+			opcode, payload := readServerFrame(t, reader)
+			if opcode != opClose || len(payload) < 2 {
+				t.Fatalf("got opcode %d %v, want a close", opcode, payload)
+			}
+			if code := int(binary.BigEndian.Uint16(payload)); code != tt.wantCode {
+				t.Errorf("close code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	serverErr := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		conn.IdleTimeout = 100 * time.Millisecond
+		_, _, err = conn.ReadMessage()
+		serverErr <- err
+	}))
+	defer srv.Close()
+
+	dial(t, srv.URL)
+
+	var netErr net.Error
+	if err := <-serverErr; !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("ReadMessage() error = %v, want a timeout", err)
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+)
+
+const (
+	loginFailureKindAccount    = "account"
+	loginFailureKindIP         = "ip"
+	loginFailureKindResetEmail = "reset_email"
+	loginFailureKindResetIP    = "reset_ip"
+)
+
+// A single account gets few guesses, an IP (possibly shared by many users
+// behind a NAT) gets more before it's slowed down
+var (
+	accountLockoutPolicy = auth.LockoutPolicy{
+		FreeAttempts:    3,
+		MaxAttempts:     10,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutDuration: 15 * time.Minute,
+		ResetAfter:      24 * time.Hour,
+	}
+	ipLockoutPolicy = auth.LockoutPolicy{
+		FreeAttempts:    20,
+		MaxAttempts:     100,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutDuration: time.Hour,
+		ResetAfter:      24 * time.Hour,
+	}
+
+	// Every password reset request sends an email, so an address only
+	// gets a few before they slow down. Anyone can request a reset for
+	// any address, so it's never locked out: that would let a stranger
+	// keep the owner from resetting their password. Only the IP is.
+	resetEmailLockoutPolicy = auth.LockoutPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     10 * time.Minute,
+		ResetAfter:   24 * time.Hour,
+	}
+	resetIPLockoutPolicy = auth.LockoutPolicy{
+		FreeAttempts:    10,
+		MaxAttempts:     50,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutDuration: time.Hour,
+		ResetAfter:      24 * time.Hour,
+	}
+)
+
+// loginAccountKey tracks failures by the email that was typed, whether or
+// not an account exists, so lockouts don't reveal registered emails either
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginCounter is one of the counters an attempt is checked against
+type loginCounter struct {
+	kind   string
+	key    string
+	policy auth.LockoutPolicy
+}
+
+func loginCounters(email, ip string) []loginCounter {
+	// Always locked in this order, so concurrent attempts can't deadlock
+	return []loginCounter{
+		{kind: loginFailureKindAccount, key: loginAccountKey(email), policy: accountLockoutPolicy},
+		{kind: loginFailureKindIP, key: ip, policy: ipLockoutPolicy},
+	}
+}
+
+func passwordResetCounters(email, ip string) []loginCounter {
+	return []loginCounter{
+		{kind: loginFailureKindResetEmail, key: loginAccountKey(email), policy: resetEmailLockoutPolicy},
+		{kind: loginFailureKindResetIP, key: ip, policy: resetIPLockoutPolicy},
+	}
+}
+
+// reserveAttempt counts an attempt as failed before the password or code
+// is checked, unless one of the counters is backing off, in which case it
+// returns when the next attempt is allowed. The counters are locked while
+// deciding, so a burst of parallel attempts can't all slip through on the
+// same count.
+func (cfg *apiConfig) reserveAttempt(ctx context.Context, counters []loginCounter) (time.Time, error) {
+	var blockedUntil time.Time
+	err := cfg.inTx(ctx, func(q *database.Queries) error {
+		for _, c := range counters {
+			failure, err := q.LockLoginFailure(ctx, database.LockLoginFailureParams{
+				Kind: c.kind,
+				Key:  c.key,
+			})
+			if err != nil {
+				return err
+			}
+			until := c.policy.BlockedUntil(int(failure.Failures), failure.LastFailureAt)
+			if until.After(blockedUntil) {
+				blockedUntil = until
+			}
+		}
+		if time.Until(blockedUntil) > 0 {
+			return nil
+		}
+
+		for _, c := range counters {
+			_, err := q.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+				Kind:          c.kind,
+				Key:           c.key,
+				LastFailureAt: time.Now().UTC().Add(-c.policy.ResetAfter),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return blockedUntil, err
+}
+
+// releaseLoginAttempt takes back the failure reserveLoginAttempt counted,
+// once the password or code turned out to be right
+func (cfg *apiConfig) releaseLoginAttempt(ctx context.Context, email, ip string) {
+	for _, c := range loginCounters(email, ip) {
+		err := cfg.dbQueries.ReleaseLoginFailure(ctx, database.ReleaseLoginFailureParams{
+			Kind: c.kind,
+			Key:  c.key,
+		})
+		if err != nil {
+			log.Printf("Error releasing login attempt: %s", err)
+		}
+	}
+}
+
+// clearLoginFailures resets the account counter after a successful login.
+// The IP counter is left alone so one valid account can't be used to wipe
+// the record of guesses against others.
+func (cfg *apiConfig) clearLoginFailures(ctx context.Context, email string) {
+	err := cfg.dbQueries.ClearLoginFailures(ctx, database.ClearLoginFailuresParams{
+		Kind: loginFailureKindAccount,
+		Key:  loginAccountKey(email),
+	})
+	if err != nil {
+		log.Printf("Error clearing login failures: %s", err)
+	}
+}
+
+// checkLoginThrottle reserves a login attempt. It writes a 429 with
+// Retry-After and returns false when the caller has to wait before trying
+// again. Once the attempt succeeds, call releaseLoginAttempt; a failed one
+// has already been counted.
+func (cfg *apiConfig) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	return cfg.checkThrottle(w, r, loginCounters(email, getClientIP(r)), "Too many failed login attempts, try again later")
+}
+
+// checkPasswordResetThrottle is checkLoginThrottle for password reset
+// requests, which are never released. It's keyed by the email typed in,
+// so a 429 doesn't tell whether the account exists.
+func (cfg *apiConfig) checkPasswordResetThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	return cfg.checkThrottle(w, r, passwordResetCounters(email, getClientIP(r)), "Too many password reset requests, try again later")
+}
+
+func (cfg *apiConfig) checkThrottle(w http.ResponseWriter, r *http.Request, counters []loginCounter, message string) bool {
+	blockedUntil, err := cfg.reserveAttempt(r.Context(), counters)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check attempts", err)
+		return false
+	}
+
+	wait := time.Until(blockedUntil)
+	if wait <= 0 {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, message, nil)
+	return false
+}
+
+// pruneLoginFailures forgets counters nobody has touched for a while
+func (cfg *apiConfig) pruneLoginFailures(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().UTC().Add(-ipLockoutPolicy.ResetAfter)
+		err := cfg.dbQueries.DeleteStaleLoginFailures(context.Background(), cutoff)
+		if err != nil {
+			log.Printf("Error pruning login failures: %s", err)
+		}
+	}
+}
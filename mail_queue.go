@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+)
+
+const (
+	// mailQueueSize is how many emails can wait to be sent. When it's full
+	// new ones are dropped, so a flood of requests can't pile up goroutines
+	// or hammer the mail server.
+	mailQueueSize = 100
+	mailWorkers   = 2
+)
+
+// queueEmail hands send to the mail workers. It reports false, and drops
+// the email, when the queue is full.
+func (cfg *apiConfig) queueEmail(kind string, send func()) bool {
+	select {
+	case cfg.mailQueue <- send:
+		return true
+	default:
+		log.Printf("Mail queue is full, dropping %s email", kind)
+		return false
+	}
+}
+
+// sendQueuedEmails sends emails from the queue one at a time, forever
+func (cfg *apiConfig) sendQueuedEmails() {
+	for send := range cfg.mailQueue {
+		send()
+	}
+}
@@ -10,16 +10,39 @@ import (
 	"github.com/google/uuid"
 	"time"
 	"os"
+	"strconv"
+	"strings"
+	"math"
+	"path/filepath"
 	"database/sql"
+	"github.com/x6Nenko/Chirpy/internal/auth"
 	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/events"
+	"github.com/x6Nenko/Chirpy/internal/mailer"
+	"github.com/x6Nenko/Chirpy/internal/payments"
+	"github.com/x6Nenko/Chirpy/internal/stream"
+	"github.com/x6Nenko/Chirpy/internal/webhooks"
 )
 
+// webRoot is the directory served at /app/. Nothing private may be written
+// inside it.
+const webRoot = "."
+
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db 						 *sql.DB
 	dbQueries  		 *database.Queries
 	platform 			 string
-	jwtSecret 		 string
-	polkaKey			 string
+	jwtKeys 		 *auth.KeyRing
+	denylist 		 *auth.Denylist
+	webhookProviders map[string]payments.Provider
+	webhookSender  *webhooks.Sender
+	mailer 				 mailer.Mailer
+	appURL 				 string
+	passwordPolicy auth.PasswordPolicy
+	eventSinks 		 []eventSink
+	streamHub 		 *stream.Hub
+	mailQueue 		 chan func()
 }
 
 type User struct {
@@ -28,6 +51,9 @@ type User struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 	Email       string    `json:"email"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
+	EmailVerified bool    `json:"email_verified"`
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+	Role        string    `json:"role"`
 }
 
 func main() {
@@ -40,13 +66,188 @@ func main() {
 	if platformEnv == "" {
 		log.Fatal("PLATFORM must be set")
 	}
-	secretEnv := os.Getenv("SECRET")
-	if secretEnv == "" {
-		log.Fatal("SECRET must be set")
+	jwtAlgEnv := os.Getenv("JWT_ALG")
+	if jwtAlgEnv == "" {
+		jwtAlgEnv = auth.AlgHS256
+	}
+
+	var jwtKeys *auth.KeyRing
+	if jwtAlgEnv == auth.AlgHS256 {
+		secretEnv := os.Getenv("SECRET")
+		if secretEnv == "" {
+			log.Fatal("SECRET must be set")
+		}
+		jwtKeys = auth.NewHMACKeyRing(secretEnv)
+	} else {
+		// Retired keys must outlive every token they signed
+		maxTokenTTL := max(accessTokenTTL, oauthAccessTokenTTL, mfaTokenTTL)
+		keyRetain := envDuration("JWT_KEY_RETAIN", maxTokenTTL)
+		if keyRetain < maxTokenTTL {
+			log.Fatalf("JWT_KEY_RETAIN must be at least %s, the longest token lifetime", maxTokenTTL)
+		}
+		keyRing, err := auth.NewKeyRing(jwtAlgEnv, os.Getenv("JWT_KEYS_DIR"), keyRetain)
+		if err != nil {
+			log.Fatalf("Error loading JWT signing keys: %s", err)
+		}
+		jwtKeys = keyRing
 	}
+
+	var jwtRotationInterval time.Duration
+	if rotationEnv := os.Getenv("JWT_ROTATION_INTERVAL"); rotationEnv != "" {
+		interval, err := time.ParseDuration(rotationEnv)
+		if err != nil {
+			log.Fatalf("Invalid JWT_ROTATION_INTERVAL: %s", err)
+		}
+		jwtRotationInterval = interval
+	}
+	// Two secrets are accepted so a provider can be moved to a new one
+	// without dropping webhooks in between
+	polkaWebhookSecrets := envSecrets("POLKA_WEBHOOK_SECRET")
 	polkaKeyEnv := os.Getenv("POLKA_KEY")
-	if polkaKeyEnv == "" {
-		log.Fatal("POLKA_KEY must be set")
+	if polkaKeyEnv == "" && len(polkaWebhookSecrets) == 0 {
+		log.Fatal("POLKA_WEBHOOK_SECRET (or the legacy POLKA_KEY) must be set")
+	}
+	if len(polkaWebhookSecrets) == 0 {
+		log.Print("POLKA_WEBHOOK_SECRET is not set, Polka webhooks are only checked against POLKA_KEY")
+	}
+	webhookProviders := map[string]payments.Provider{
+		webhookProviderPolka: &payments.PolkaProvider{
+			Secrets:   polkaWebhookSecrets,
+			Tolerance: envDuration("POLKA_WEBHOOK_TOLERANCE", 5*time.Minute),
+			APIKey:    polkaKeyEnv,
+		},
+	}
+
+	// Providers sending our own signed JSON format only need configuring
+	for _, name := range strings.Split(os.Getenv("SIGNED_WEBHOOK_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		// "subscriptions" would be shadowed by the outgoing webhook routes
+		if _, exists := webhookProviders[name]; exists || name == "subscriptions" {
+			log.Fatalf("Webhook provider %q is taken", name)
+		}
+		secretEnv := "SIGNED_WEBHOOK_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_SECRET"
+		secrets := envSecrets(secretEnv)
+		if len(secrets) == 0 {
+			log.Fatalf("%s must be set for webhook provider %q", secretEnv, name)
+		}
+		webhookProviders[name] = &payments.SignedJSONProvider{
+			Secrets:   secrets,
+			Tolerance: envDuration("SIGNED_WEBHOOK_TOLERANCE", 5*time.Minute),
+		}
+	}
+	appURLEnv := os.Getenv("APP_URL")
+	if appURLEnv == "" {
+		appURLEnv = "http://localhost:8080"
+	}
+	mailFromEnv := os.Getenv("MAIL_FROM")
+	if mailFromEnv == "" {
+		mailFromEnv = "Chirpy <noreply@chirpy.local>"
+	}
+
+	argon2Params := auth.PasswordParams()
+	argon2Memory := envInt("ARGON2_MEMORY", int(argon2Params.Memory))
+	argon2Iterations := envInt("ARGON2_ITERATIONS", int(argon2Params.Iterations))
+	argon2Parallelism := envInt("ARGON2_PARALLELISM", int(argon2Params.Parallelism))
+	// Checked before the conversions, which would wrap out of range values
+	if argon2Memory < 1 || int64(argon2Memory) > math.MaxUint32 {
+		log.Fatalf("ARGON2_MEMORY must be between 1 and %d KiB", uint32(math.MaxUint32))
+	}
+	if argon2Iterations < 1 || int64(argon2Iterations) > math.MaxUint32 {
+		log.Fatalf("ARGON2_ITERATIONS must be between 1 and %d", uint32(math.MaxUint32))
+	}
+	if argon2Parallelism < 1 || argon2Parallelism > math.MaxUint8 {
+		log.Fatalf("ARGON2_PARALLELISM must be between 1 and %d", math.MaxUint8)
+	}
+	argon2Params.Memory = uint32(argon2Memory)
+	argon2Params.Iterations = uint32(argon2Iterations)
+	argon2Params.Parallelism = uint8(argon2Parallelism)
+	err := auth.SetPasswordParams(argon2Params)
+	if err != nil {
+		log.Fatalf("Invalid argon2id parameters: %s", err)
+	}
+
+	passwordPolicy := auth.PasswordPolicy{
+		MinLength: envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength: envInt("PASSWORD_MAX_LENGTH", 128),
+	}
+	if breachedFileEnv := os.Getenv("BREACHED_PASSWORDS_FILE"); breachedFileEnv != "" {
+		breached, err := auth.LoadBreachedPasswords(breachedFileEnv, 0.001)
+		if err != nil {
+			log.Fatalf("Error loading breached passwords: %s", err)
+		}
+		log.Printf("Loaded %d breached passwords\n", breached.Len())
+		passwordPolicy.Breached = breached
+	}
+
+	var mail mailer.Mailer
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		smtpAddrEnv := os.Getenv("SMTP_ADDR")
+		if smtpAddrEnv == "" {
+			log.Fatal("SMTP_ADDR must be set when MAILER=smtp")
+		}
+		mail = &mailer.SMTPMailer{
+			Addr:     smtpAddrEnv,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFromEnv,
+		}
+	case "file":
+		// The emails hold live reset and verification tokens, so they must
+		// not be reachable through /app/
+		mailDirEnv := os.Getenv("MAILER_DIR")
+		if mailDirEnv == "" {
+			mailDirEnv = filepath.Join(os.TempDir(), "chirpy-mail")
+		}
+		if insideWebRoot(mailDirEnv) {
+			log.Fatalf("MAILER_DIR %q is inside the web root %q, which is served at /app/", mailDirEnv, webRoot)
+		}
+		mail = &mailer.FileMailer{Dir: mailDirEnv, From: mailFromEnv}
+	case "", "console":
+		mail = mailer.NewConsoleMailer(mailFromEnv, os.Stdout)
+	default:
+		log.Fatal("MAILER must be one of smtp, file or console")
+	}
+
+	// The bus always gets outbox events, EVENT_SINKS adds more places to
+	// publish them to
+	eventBus := events.NewBus()
+	eventSinks := []eventSink{{name: "bus", sink: eventBus}}
+	for _, name := range strings.Split(os.Getenv("EVENT_SINKS"), ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "bus":
+			continue
+		case "file":
+			// Events carry emails and billing state, keep them off /app/
+			eventFileEnv := os.Getenv("EVENT_FILE")
+			if eventFileEnv == "" {
+				eventFileEnv = filepath.Join(os.TempDir(), "chirpy-events.jsonl")
+			}
+			if insideWebRoot(eventFileEnv) {
+				log.Fatalf("EVENT_FILE %q is inside the web root %q, which is served at /app/", eventFileEnv, webRoot)
+			}
+			eventSinks = append(eventSinks, eventSink{name: name, sink: &events.FileSink{Path: eventFileEnv}})
+		case "nats":
+			natsAddrEnv := os.Getenv("NATS_ADDR")
+			if natsAddrEnv == "" {
+				natsAddrEnv = "localhost:4222"
+			}
+			natsPrefixEnv := os.Getenv("NATS_SUBJECT_PREFIX")
+			if natsPrefixEnv == "" {
+				natsPrefixEnv = "chirpy"
+			}
+			eventSinks = append(eventSinks, eventSink{name: name, sink: &events.NATSSink{
+				Addr:    natsAddrEnv,
+				Prefix:  natsPrefixEnv,
+				Timeout: envDuration("NATS_TIMEOUT", 5*time.Second),
+			}})
+		default:
+			log.Fatalf("Unknown event sink %q, EVENT_SINKS takes file and nats", name)
+		}
 	}
 
 	dbConn, err := sql.Open("postgres", dbURL)
@@ -56,10 +257,40 @@ func main() {
 	queries := database.New(dbConn)
 	apiCfg := &apiConfig{
 		fileserverHits: atomic.Int32{},
+		db:							dbConn,
 		dbQueries: 			queries,
 		platform:				platformEnv,
-		jwtSecret:			secretEnv,
-		polkaKey:				polkaKeyEnv,
+		jwtKeys:				jwtKeys,
+		denylist:				auth.NewDenylist(denylistStore{db: queries}, 10000, 30*time.Second),
+		webhookProviders: webhookProviders,
+		webhookSender:    webhooks.NewSender(webhookSendTimeout, platformEnv == "dev"),
+		mailer:					mail,
+		appURL:					appURLEnv,
+		passwordPolicy:	passwordPolicy,
+		eventSinks:			eventSinks,
+		streamHub:			stream.NewHub(envInt("STREAM_REPLAY_BUFFER", 1000)),
+		mailQueue:			make(chan func(), mailQueueSize),
+	}
+
+	eventBus.Subscribe(webhooks.EventChirpCreated, apiCfg.enqueueWebhookDeliveries)
+	eventBus.Subscribe(webhooks.EventChirpDeleted, apiCfg.enqueueWebhookDeliveries)
+	eventBus.Subscribe(eventChirpCreated, apiCfg.notifyStream)
+	eventBus.Subscribe(eventChirpDeleted, apiCfg.notifyStream)
+
+	if jwtRotationInterval > 0 && jwtAlgEnv != auth.AlgHS256 {
+		go apiCfg.rotateSigningKeys(jwtRotationInterval)
+	}
+
+	go apiCfg.pruneRevokedAccessTokens(time.Hour)
+	go apiCfg.pruneLoginFailures(time.Hour)
+	go apiCfg.purgeDeletedUsers(time.Hour)
+	go apiCfg.expireLapsedSubscriptions(time.Hour)
+	go apiCfg.deliverWebhooks(5 * time.Second)
+	go apiCfg.relayOutbox(time.Second)
+	go apiCfg.pruneOutbox(time.Hour)
+	go apiCfg.listenForStreamEvents(dbURL)
+	for range mailWorkers {
+		go apiCfg.sendQueuedEmails()
 	}
 
 	// Creating a new ServeMux
@@ -68,34 +299,141 @@ func main() {
 	// Creating a server struct
 	server := &http.Server{
 		Addr:    ":8080",
-		Handler: ServeMux,
+		Handler: middlewareRequestID(ServeMux),
 	}
 
-	fs := http.FileServer(http.Dir("."))
+	fs := http.FileServer(http.Dir(webRoot))
 	ServeMux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app/", fs)))
 	ServeMux.HandleFunc("GET /api/healthz", handlerReadiness)
+	ServeMux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 
 	ServeMux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
 	ServeMux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGetOne)
 	ServeMux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsGetAll)
 	ServeMux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	ServeMux.HandleFunc("GET /api/stream", apiCfg.handlerStream)
+	ServeMux.HandleFunc("GET /api/ws", apiCfg.handlerWebSocket)
 
 	ServeMux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhooks)
+	ServeMux.HandleFunc("POST /api/webhooks/{provider}", apiCfg.handlerWebhooks)
+	ServeMux.HandleFunc("POST /api/webhooks/subscriptions", apiCfg.handlerWebhookSubscriptionsCreate)
+	ServeMux.HandleFunc("GET /api/webhooks/subscriptions", apiCfg.handlerWebhookSubscriptionsGetAll)
+	ServeMux.HandleFunc("DELETE /api/webhooks/subscriptions/{subscriptionID}", apiCfg.handlerWebhookSubscriptionsDelete)
+	ServeMux.HandleFunc("POST /api/webhooks/subscriptions/{subscriptionID}/enable", apiCfg.handlerWebhookSubscriptionsEnable)
+	ServeMux.HandleFunc("GET /api/webhooks/subscriptions/{subscriptionID}/deliveries", apiCfg.handlerWebhookDeliveriesGetAll)
 
 	ServeMux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
 	ServeMux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	ServeMux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
 	ServeMux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	ServeMux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	ServeMux.HandleFunc("POST /api/logout", apiCfg.handlerLogout)
 	ServeMux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	ServeMux.HandleFunc("PATCH /api/users", apiCfg.handlerUsersPatch)
+	ServeMux.HandleFunc("GET /api/users/me/export", apiCfg.handlerUsersExport)
+	ServeMux.HandleFunc("DELETE /api/users/me", apiCfg.handlerUsersDelete)
+	ServeMux.HandleFunc("POST /api/users/verify-email", apiCfg.handlerEmailVerify)
+	ServeMux.HandleFunc("POST /api/users/verify-email/resend", apiCfg.handlerEmailVerifyResend)
+	ServeMux.HandleFunc("POST /api/users/2fa/enroll", apiCfg.handlerTOTPEnroll)
+	ServeMux.HandleFunc("POST /api/users/2fa/confirm", apiCfg.handlerTOTPConfirm)
+	ServeMux.HandleFunc("DELETE /api/users/2fa", apiCfg.handlerTOTPDisable)
+	ServeMux.HandleFunc("POST /api/password-reset/request", apiCfg.handlerPasswordResetRequest)
+	ServeMux.HandleFunc("POST /api/password-reset/confirm", apiCfg.handlerPasswordResetConfirm)
+
+	ServeMux.HandleFunc("GET /api/sessions", apiCfg.handlerSessionsGetAll)
+	ServeMux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerSessionsDelete)
+	ServeMux.HandleFunc("POST /api/sessions/revoke-all", apiCfg.handlerSessionsRevokeAll)
+	ServeMux.HandleFunc("POST /api/keys", apiCfg.handlerAPIKeysCreate)
+	ServeMux.HandleFunc("GET /api/keys", apiCfg.handlerAPIKeysGetAll)
+	ServeMux.HandleFunc("DELETE /api/keys/{keyID}", apiCfg.handlerAPIKeysDelete)
+	ServeMux.HandleFunc("POST /api/oauth/clients", apiCfg.handlerOAuthClientsCreate)
+	ServeMux.HandleFunc("GET /api/oauth/clients", apiCfg.handlerOAuthClientsGetAll)
+	ServeMux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.handlerOAuthClientsDelete)
+	ServeMux.HandleFunc("GET /api/oauth/consent", apiCfg.handlerOAuthConsentGet)
+	ServeMux.HandleFunc("POST /api/oauth/consent", apiCfg.handlerOAuthConsent)
+	ServeMux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	ServeMux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	ServeMux.HandleFunc("POST /oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	ServeMux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
 
-	ServeMux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
-	ServeMux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	ServeMux.Handle("GET /admin/metrics", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerMetrics))
+	ServeMux.Handle("POST /admin/reset", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerReset))
+	ServeMux.Handle("GET /admin/users", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerAdminUsersSearch))
+	ServeMux.Handle("GET /admin/users/{userID}", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerAdminUsersGet))
+	ServeMux.Handle("POST /admin/users/{userID}/revoke-sessions", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerAdminUsersRevokeSessions))
+	ServeMux.Handle("POST /admin/users/{userID}/suspend", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerAdminUsersSuspend))
+	ServeMux.Handle("POST /admin/users/{userID}/unsuspend", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerAdminUsersUnsuspend))
+	ServeMux.Handle("POST /admin/users/{userID}/password-reset", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminUsersPasswordReset))
+	ServeMux.Handle("PUT /admin/users/{userID}/chirpy-red", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminUsersChirpyRed))
+	ServeMux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminUsersRole))
+	ServeMux.Handle("GET /admin/audit", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminAudit))
+	ServeMux.Handle("GET /admin/webhooks/events", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminWebhookEventsList))
+	ServeMux.Handle("POST /admin/webhooks/events/{eventID}/replay", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminWebhookEventsReplay))
+	ServeMux.Handle("GET /admin/outbox/failed", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminOutboxFailed))
+	ServeMux.Handle("POST /admin/outbox/{eventID}/requeue", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerAdminOutboxRequeue))
 
 	// Start the server
 	log.Printf("Serving on port: 8080\n")
 	log.Fatal(server.ListenAndServe())
 }
 
+// envInt reads an integer environment variable, falling back to def when unset
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be a number", name)
+	}
+	return n
+}
+
+// envDuration reads a positive duration environment variable, falling back
+// to def when unset
+// insideWebRoot reports whether path is webRoot or somewhere below it, so
+// anything written there could be downloaded from /app/
+func insideWebRoot(path string) bool {
+	root, err := filepath.Abs(webRoot)
+	if err != nil {
+		return true
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Fatalf("Invalid %s: %q", name, value)
+	}
+	return parsed
+}
+
+// envSecrets reads a secret and, while it's being rotated, the one before
+// it from <name>_PREVIOUS
+func envSecrets(name string) []string {
+	secrets := []string{}
+	for _, env := range []string{name, name + "_PREVIOUS"} {
+		if secret := os.Getenv(env); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
 func handlerReadiness(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -123,13 +461,29 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 
 	cfg.fileserverHits.Store(0)
 
-	err := cfg.dbQueries.Reset(r.Context())
+	// Users go one by one so their deletions reach the outbox
+	err := cfg.inTx(r.Context(), func(q *database.Queries) error {
+		users, err := q.ListUsers(r.Context())
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			err = deleteUser(r.Context(), q, user)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to reset the database: " + err.Error()))
 		return
 	}
 
+	// The audit log itself survives, it can't be deleted from
+	cfg.recordAudit(r, auditActor(claimsFromContext(r.Context()).UserID), auditAdminReset, "", "")
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hits reset to 0 and database reset to initial state."))
 }
\ No newline at end of file
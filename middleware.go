@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"github.com/x6Nenko/Chirpy/internal/auth"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -9,4 +16,191 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 		cfg.fileserverHits.Add(1)
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// authenticateRequest validates the bearer access token, rejects revoked
+// tokens and makes sure it was granted the scope the handler needs (an
+// empty scope accepts any token). On failure it has already written the
+// response, so the handler should just return.
+func (cfg *apiConfig) authenticateRequest(w http.ResponseWriter, r *http.Request, scope string) (*auth.Claims, bool) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Couldn't get bearer token", err)
+		return nil, false
+	}
+
+	claims, err := auth.ValidateJWT(tokenString, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized", err)
+		return nil, false
+	}
+
+	revoked, err := cfg.denylist.IsRevoked(r.Context(), claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token revocation", err)
+		return nil, false
+	}
+	if revoked {
+		respondWithError(w, 401, "Token has been revoked", nil)
+		return nil, false
+	}
+
+	if scope != "" && !claims.HasScope(scope) {
+		respondWithError(w, 403, "Token is missing the "+scope+" scope", nil)
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// authenticateFirstParty is authenticateRequest for managing the account
+// itself: 2FA, API keys, OAuth apps, sessions, credentials and deletion.
+// Tokens issued to OAuth apps are refused whatever their scopes.
+func (cfg *apiConfig) authenticateFirstParty(w http.ResponseWriter, r *http.Request, scope string) (*auth.Claims, bool) {
+	claims, ok := cfg.authenticateRequest(w, r, scope)
+	if !ok {
+		return nil, false
+	}
+
+	if claims.ClientID != "" {
+		respondWithError(w, 403, "Not available to OAuth apps", nil)
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// authenticateRequestOrAPIKey is authenticateRequest for endpoints that
+// integrations may also call with a personal API key in an
+// "Authorization: ApiKey <key>" header. The key's scopes are checked the
+// same way as a token's.
+func (cfg *apiConfig) authenticateRequestOrAPIKey(w http.ResponseWriter, r *http.Request, scope string) (*auth.Claims, bool) {
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return cfg.authenticateRequest(w, r, scope)
+	}
+
+	dbKey, err := cfg.dbQueries.GetAPIKeyByHash(r.Context(), auth.HashToken(apiKey))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 401, "Invalid or expired API key", nil)
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check API key", err)
+		return nil, false
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), dbKey.UserID)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired API key", err)
+		return nil, false
+	}
+	if user.SuspendedAt.Valid {
+		respondWithError(w, 403, "Account is suspended", nil)
+		return nil, false
+	}
+
+	claims := &auth.Claims{
+		Scope:  dbKey.Scope,
+		UserID: dbKey.UserID,
+	}
+	if scope != "" && !claims.HasScope(scope) {
+		respondWithError(w, 403, "API key is missing the "+scope+" scope", nil)
+		return nil, false
+	}
+
+	err = cfg.dbQueries.TouchAPIKey(r.Context(), dbKey.ID)
+	if err != nil {
+		log.Printf("Error updating API key last use: %s", err)
+	}
+
+	return claims, true
+}
+
+// middlewareRequireRole only lets through requests whose access token has
+// the admin scope and at least the given role. The database is checked
+// too, so a demotion takes effect right away rather than when the token
+// expires.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := cfg.authenticateFirstParty(w, r, auth.ScopeAdmin)
+		if !ok {
+			return
+		}
+
+		if !claims.HasRole(role) {
+			respondWithError(w, 403, "Requires the "+role+" role", nil)
+			return
+		}
+
+		user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+		if err != nil {
+			respondWithError(w, 401, "Couldn't get user", err)
+			return
+		}
+		if !auth.RoleAtLeast(user.Role, role) {
+			respondWithError(w, 403, "Requires the "+role+" role", nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		ctx = context.WithValue(ctx, actorContextKey, user)
+		next(w, r.WithContext(ctx))
+	})
+}
+
+type contextKey string
+
+const (
+	claimsContextKey contextKey = "claims"
+	actorContextKey  contextKey = "actor"
+)
+
+// claimsFromContext returns the claims middlewareRequireRole authenticated
+func claimsFromContext(ctx context.Context) *auth.Claims {
+	claims, _ := ctx.Value(claimsContextKey).(*auth.Claims)
+	return claims
+}
+
+// actorFromContext returns the user middlewareRequireRole loaded, whose
+// role is the current one rather than the token's snapshot
+func actorFromContext(ctx context.Context) database.User {
+	user, _ := ctx.Value(actorContextKey).(database.User)
+	return user
+}
+
+const requestIDContextKey contextKey = "request_id"
+
+// middlewareRequestID tags every request with an ID, echoed in the
+// X-Request-ID response header. A sane ID sent by a proxy in front of us is
+// kept so the logs on both sides line up.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for _, c := range requestID {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFromContext returns the ID middlewareRequestID assigned
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/events"
+	"github.com/x6Nenko/Chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+// Delivery states of a webhook_deliveries row
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryFailed    = "failed"
+)
+
+const (
+	// webhookDisableAfter consecutive failed attempts turn a subscription off
+	webhookDisableAfter = 15
+	webhookSendTimeout  = 10 * time.Second
+	webhookBatchSize    = 50
+)
+
+// webhookPayload is the body of every delivery
+type webhookPayload struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// enqueueWebhookDeliveries queues a domain event for every endpoint its
+// user subscribed to it. It's subscribed to the event bus, so an error,
+// here or in another bus handler, makes the outbox relay publish the event
+// again; endpoints that already have it aren't given it twice.
+func (cfg *apiConfig) enqueueWebhookDeliveries(ctx context.Context, event events.Event) error {
+	var owner struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	err := json.Unmarshal(event.Data, &owner)
+	if err != nil {
+		return fmt.Errorf("couldn't decode %s event: %w", event.Type, err)
+	}
+
+	// The event ID lets receivers spot an event delivered twice
+	payload, err := json.Marshal(webhookPayload{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.OccurredAt,
+		Data:      event.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.dbQueries.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventType: event.Type,
+		Payload:   string(payload),
+		EventID:   uuid.NullUUID{UUID: event.ID, Valid: true},
+		UserID:    owner.UserID,
+	})
+	return err
+}
+
+// deliverWebhooks works through the outbox, sending every due delivery
+func (cfg *apiConfig) deliverWebhooks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			deliveries, err := cfg.dbQueries.ClaimDueWebhookDeliveries(context.Background(), webhookBatchSize)
+			if err != nil {
+				log.Printf("Error claiming webhook deliveries: %s", err)
+				break
+			}
+
+			for _, delivery := range deliveries {
+				cfg.deliverWebhook(context.Background(), delivery)
+			}
+			if len(deliveries) < webhookBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a claimed delivery
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, delivery database.WebhookDelivery) {
+	subscription, err := cfg.dbQueries.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		// Picked up again once the lease runs out
+		log.Printf("Couldn't get webhook subscription %s: %s", delivery.SubscriptionID, err)
+		return
+	}
+	if subscription.DisabledAt.Valid {
+		cfg.failPendingWebhookDeliveries(ctx, subscription.ID)
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, webhookSendTimeout)
+	defer cancel()
+	status, sendErr := cfg.webhookSender.Send(sendCtx, subscription.Url, subscription.Secret, webhooks.Delivery{
+		ID:      delivery.ID.String(),
+		Event:   delivery.EventType,
+		Payload: []byte(delivery.Payload),
+	})
+
+	if sendErr == nil {
+		err = cfg.dbQueries.MarkWebhookDeliveryDelivered(ctx, database.MarkWebhookDeliveryDeliveredParams{
+			ID:             delivery.ID,
+			ResponseStatus: int32(status),
+		})
+		if err != nil {
+			log.Printf("Couldn't mark webhook delivery %s delivered: %s", delivery.ID, err)
+		}
+		err = cfg.dbQueries.ResetWebhookSubscriptionFailures(ctx, subscription.ID)
+		if err != nil {
+			log.Printf("Couldn't reset failures of webhook subscription %s: %s", subscription.ID, err)
+		}
+		return
+	}
+
+	// Retry with backoff until the attempts run out
+	attempts := int(delivery.Attempts) + 1
+	deliveryStatus := webhookDeliveryPending
+	if attempts >= webhooks.MaxAttempts {
+		deliveryStatus = webhookDeliveryFailed
+	}
+	err = cfg.dbQueries.RecordWebhookDeliveryFailure(ctx, database.RecordWebhookDeliveryFailureParams{
+		ID:             delivery.ID,
+		Status:         deliveryStatus,
+		ResponseStatus: int32(status),
+		LastError:      sendErr.Error(),
+		NextAttemptAt:  time.Now().UTC().Add(webhooks.Backoff(attempts)),
+	})
+	if err != nil {
+		log.Printf("Couldn't record failed webhook delivery %s: %s", delivery.ID, err)
+	}
+
+	subscription, err = cfg.dbQueries.RecordWebhookSubscriptionFailure(ctx, database.RecordWebhookSubscriptionFailureParams{
+		DisableAfter: webhookDisableAfter,
+		ID:           subscription.ID,
+	})
+	if err != nil {
+		log.Printf("Couldn't record failure of webhook subscription %s: %s", subscription.ID, err)
+		return
+	}
+	if subscription.DisabledAt.Valid {
+		log.Printf("Disabled webhook subscription %s after %d failures", subscription.ID, subscription.FailureCount)
+		cfg.failPendingWebhookDeliveries(ctx, subscription.ID)
+	}
+}
+
+func (cfg *apiConfig) failPendingWebhookDeliveries(ctx context.Context, subscriptionID uuid.UUID) {
+	err := cfg.dbQueries.FailPendingWebhookDeliveries(ctx, database.FailPendingWebhookDeliveriesParams{
+		SubscriptionID: subscriptionID,
+		LastError:      "subscription is disabled",
+	})
+	if err != nil {
+		log.Printf("Couldn't fail deliveries of webhook subscription %s: %s", subscriptionID, err)
+	}
+}
@@ -0,0 +1,14 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (occurred_at, actor_id, action, target_type, target_id, ip_address, request_id)
+VALUES (
+  NOW(), $1, $2, $3, $4, $5, $6
+);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('actor_id')::uuid IS NULL OR actor_id = sqlc.narg('actor_id'))
+  AND (sqlc.arg('action')::text = '' OR action = sqlc.arg('action'))
+  AND occurred_at >= sqlc.arg('since')
+  AND occurred_at < sqlc.arg('until')
+ORDER BY id DESC
+LIMIT sqlc.arg('max_rows');
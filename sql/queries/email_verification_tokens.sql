@@ -0,0 +1,20 @@
+-- name: CreateEmailVerificationToken :one
+INSERT INTO email_verification_tokens (id, created_at, user_id, email, token_hash, expires_at, used_at)
+VALUES (
+  gen_random_uuid(), NOW(), $1, $2, $3, $4, NULL
+)
+RETURNING *;
+
+-- name: GetEmailVerificationTokenByHash :one
+SELECT * FROM email_verification_tokens
+WHERE token_hash = $1;
+
+-- name: UseEmailVerificationToken :execrows
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL AND expires_at > NOW();
+
+-- name: InvalidateEmailVerificationTokensForUser :exec
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
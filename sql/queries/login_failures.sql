@@ -0,0 +1,40 @@
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures
+WHERE kind = $1 AND key = $2;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_failures (kind, key, failures, last_failure_at)
+VALUES (
+  $1, $2, 1, NOW()
+)
+ON CONFLICT (kind, key) DO UPDATE
+SET failures = CASE
+    WHEN login_failures.last_failure_at < $3 THEN 1
+    ELSE login_failures.failures + 1
+  END,
+  last_failure_at = NOW()
+RETURNING *;
+
+-- name: ClearLoginFailures :exec
+DELETE FROM login_failures
+WHERE kind = $1 AND key = $2;
+
+-- name: DeleteStaleLoginFailures :exec
+DELETE FROM login_failures
+WHERE last_failure_at < $1;
+
+-- name: LockLoginFailure :one
+-- Returns the counter, creating an empty one, and locks it until the
+-- transaction ends so concurrent attempts are counted one at a time
+INSERT INTO login_failures (kind, key, failures, last_failure_at)
+VALUES (
+  $1, $2, 0, NOW()
+)
+ON CONFLICT (kind, key) DO UPDATE
+SET kind = EXCLUDED.kind
+RETURNING *;
+
+-- name: ReleaseLoginFailure :exec
+UPDATE login_failures
+SET failures = GREATEST(failures - 1, 0)
+WHERE kind = $1 AND key = $2;
@@ -0,0 +1,47 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO outbox (event_id, event_type, aggregate_type, aggregate_id, payload, occurred_at, published_at, published_to, attempts, last_error)
+VALUES (
+  $1, $2, $3, $4, $5, $6, NULL, '', 0, ''
+);
+
+-- name: DeletePublishedOutboxEvents :execrows
+DELETE FROM outbox
+WHERE published_at < $1;
+
+-- name: ListFailedOutboxEvents :many
+-- Events the relay gave up on, oldest first
+SELECT * FROM outbox
+WHERE failed_at IS NOT NULL
+ORDER BY id ASC
+LIMIT $1;
+
+-- name: LockUnpublishedOutboxEvents :many
+-- Oldest first, locked until the transaction ends so a relay in another
+-- instance skips them
+SELECT * FROM outbox
+WHERE published_at IS NULL AND failed_at IS NULL
+ORDER BY id ASC
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxEventFailed :exec
+UPDATE outbox
+SET failed_at = NOW(), published_to = $2, attempts = attempts + 1, last_error = $3
+WHERE id = $1;
+
+-- name: MarkOutboxEventPublished :exec
+UPDATE outbox
+SET published_at = NOW(), published_to = $2, attempts = attempts + 1, last_error = ''
+WHERE id = $1;
+
+-- name: RecordOutboxEventFailure :exec
+UPDATE outbox
+SET published_to = $2, attempts = attempts + 1, last_error = $3
+WHERE id = $1;
+
+-- name: RequeueOutboxEvent :one
+-- Hands a failed event back to the relay for another round of attempts
+UPDATE outbox
+SET failed_at = NULL, attempts = 0
+WHERE event_id = $1 AND failed_at IS NOT NULL
+RETURNING *;
@@ -1,2 +0,0 @@
--- name: Reset :exec
-DELETE FROM users;
\ No newline at end of file
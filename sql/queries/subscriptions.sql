@@ -0,0 +1,59 @@
+-- name: ActivateSubscription :one
+-- An existing subscription is only updated if the change isn't older than
+-- the last one applied to it
+INSERT INTO subscriptions (id, user_id, plan, status, current_period_end, grace_period_end, canceled_at, created_at, updated_at, last_event_at)
+VALUES (
+  gen_random_uuid(), $1, $2, 'active', $3, $4, NULL, NOW(), NOW(), $5
+)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan,
+    status = 'active',
+    current_period_end = EXCLUDED.current_period_end,
+    grace_period_end = EXCLUDED.grace_period_end,
+    canceled_at = NULL,
+    updated_at = NOW(),
+    last_event_at = EXCLUDED.last_event_at
+WHERE subscriptions.last_event_at IS NULL OR subscriptions.last_event_at <= EXCLUDED.last_event_at
+RETURNING *;
+
+-- name: CancelSubscription :one
+UPDATE subscriptions
+SET status = 'canceled',
+    canceled_at = NOW(),
+    grace_period_end = COALESCE(LEAST(current_period_end, grace_period_end), NOW()),
+    updated_at = NOW(),
+    last_event_at = $2
+WHERE user_id = $1 AND status IN ('active', 'past_due')
+RETURNING *;
+
+-- name: ExpireLapsedSubscriptions :many
+UPDATE subscriptions
+SET status = 'expired', updated_at = NOW()
+WHERE status <> 'expired' AND grace_period_end <= NOW()
+RETURNING *;
+
+-- name: ExpireSubscription :one
+UPDATE subscriptions
+SET status = 'expired', grace_period_end = NOW(), updated_at = NOW(), last_event_at = $2
+WHERE user_id = $1 AND status <> 'expired'
+RETURNING *;
+
+-- name: GetSubscriptionForUser :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: LockSubscriptionForUser :one
+-- Locked until the transaction ends, so changes to one subscription are
+-- applied one at a time
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: MarkSubscriptionPastDue :one
+UPDATE subscriptions
+SET status = 'past_due',
+    grace_period_end = CASE WHEN status = 'past_due' THEN grace_period_end ELSE sqlc.narg('grace_period_end') END,
+    updated_at = NOW(),
+    last_event_at = sqlc.arg('last_event_at')
+WHERE user_id = sqlc.arg('user_id') AND status IN ('active', 'past_due')
+RETURNING *;
@@ -15,8 +15,112 @@ RETURNING *;
 SELECT * FROM users
 WHERE email = $1;
 
--- name: UpdateUserChirpyRed :one
+-- name: SyncUserChirpyRed :one
 UPDATE users
-SET is_chirpy_red = $1, updated_at = NOW()
+SET is_chirpy_red = EXISTS (
+  SELECT 1 FROM subscriptions
+  WHERE subscriptions.user_id = users.id
+    AND status <> 'expired'
+    AND (grace_period_end IS NULL OR grace_period_end > NOW())
+), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+SET hashed_password = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: UpdateUserEmail :one
+UPDATE users
+SET email = $1, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: SetUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $1, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $2;
+
+-- name: EnableUserTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: DisableUserTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = $1
+WHERE id = $2 AND totp_last_step < $1;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = $1
+WHERE id = $2 AND hashed_password = $3;
+
+-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET delete_after = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, updated_at = NOW()
+WHERE id = $1 AND delete_after IS NOT NULL;
+
+-- name: ListScheduledUserDeletions :many
+SELECT * FROM users
+WHERE delete_after <= $1
+ORDER BY delete_after ASC
+FOR UPDATE;
+
+-- name: ListUsers :many
+SELECT * FROM users
+ORDER BY created_at ASC
+FOR UPDATE;
+
+-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1;
+
+-- name: UpdateUserRole :one
+UPDATE users
+SET role = $1, updated_at = NOW()
 WHERE id = $2
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users
+WHERE role = $1;
+
+-- name: GetOldestUser :one
+SELECT * FROM users
+ORDER BY created_at ASC
+LIMIT 1;
+
+-- name: SearchUsersByEmail :many
+SELECT * FROM users
+WHERE email LIKE $1
+ORDER BY email ASC
+LIMIT $2;
+
+-- name: SuspendUser :one
+UPDATE users
+SET suspended_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UnsuspendUser :one
+UPDATE users
+SET suspended_at = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,57 @@
+-- name: ClaimDueWebhookDeliveries :many
+-- Leases due deliveries by pushing next_attempt_at past the time a delivery
+-- can take, so other instances leave them alone meanwhile. Endpoints of
+-- accounts scheduled for deletion are paused, their deliveries wait.
+UPDATE webhook_deliveries
+SET next_attempt_at = NOW() + INTERVAL '5 minutes'
+WHERE id IN (
+  SELECT id FROM webhook_deliveries
+  WHERE status = 'pending' AND next_attempt_at <= NOW()
+    AND subscription_id NOT IN (
+      SELECT webhook_subscriptions.id FROM webhook_subscriptions
+      JOIN users ON users.id = webhook_subscriptions.user_id
+      WHERE users.delete_after IS NOT NULL
+    )
+  ORDER BY next_attempt_at ASC
+  LIMIT $1
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- Endpoints that already have the event are skipped, so enqueueing it
+-- again is harmless
+INSERT INTO webhook_deliveries (id, created_at, subscription_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, last_attempt_at, delivered_at, event_id)
+SELECT gen_random_uuid(), NOW(), webhook_subscriptions.id, sqlc.arg('event_type'), sqlc.arg('payload'), 'pending', 0, 0, '', NOW(), NULL, NULL, sqlc.arg('event_id')
+FROM webhook_subscriptions
+WHERE user_id = sqlc.arg('user_id')
+  AND disabled_at IS NULL
+  AND sqlc.arg('event_type')::text = ANY(string_to_array(events, ' '))
+ON CONFLICT (subscription_id, event_id) DO NOTHING;
+
+-- name: FailPendingWebhookDeliveries :exec
+UPDATE webhook_deliveries
+SET status = 'failed', last_error = $2
+WHERE subscription_id = $1 AND status = 'pending';
+
+-- name: GetWebhookDeliveriesForSubscription :many
+SELECT * FROM webhook_deliveries
+WHERE subscription_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: GetWebhookDeliveriesForUser :many
+SELECT webhook_deliveries.* FROM webhook_deliveries
+JOIN webhook_subscriptions ON webhook_subscriptions.id = webhook_deliveries.subscription_id
+WHERE webhook_subscriptions.user_id = $1
+ORDER BY webhook_deliveries.created_at ASC;
+
+-- name: MarkWebhookDeliveryDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered', attempts = attempts + 1, response_status = $2, last_error = '', last_attempt_at = NOW(), delivered_at = NOW()
+WHERE id = $1;
+
+-- name: RecordWebhookDeliveryFailure :exec
+UPDATE webhook_deliveries
+SET status = $2, attempts = attempts + 1, response_status = $3, last_error = $4, last_attempt_at = NOW(), next_attempt_at = $5
+WHERE id = $1;
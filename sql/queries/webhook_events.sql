@@ -0,0 +1,37 @@
+-- name: CreateWebhookEvent :one
+INSERT INTO webhook_events (id, provider, event_id, event_type, payload, status, response_status, error, attempts, received_at, processed_at, updated_at)
+VALUES (
+  gen_random_uuid(), $1, $2, $3, $4, 'processing', 0, '', 1, NOW(), NULL, NOW()
+)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING *;
+
+-- name: FinishWebhookEvent :one
+UPDATE webhook_events
+SET status = $2, response_status = $3, error = $4, processed_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: GetWebhookEventByEventID :one
+SELECT * FROM webhook_events
+WHERE provider = $1 AND event_id = $2;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE (sqlc.arg('provider')::text = '' OR provider = sqlc.arg('provider'))
+  AND (sqlc.arg('status')::text = '' OR status = sqlc.arg('status'))
+ORDER BY received_at DESC
+LIMIT sqlc.arg('max_rows');
+
+-- name: RetryWebhookEvent :one
+-- Claims a failed event, or one left processing since before stale_before
+-- by an instance that died while applying it
+UPDATE webhook_events
+SET status = 'processing', attempts = attempts + 1, updated_at = NOW()
+WHERE id = sqlc.arg('id')
+  AND (status = 'failed' OR (status = 'processing' AND updated_at < sqlc.arg('stale_before')))
+RETURNING *;
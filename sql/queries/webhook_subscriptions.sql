@@ -0,0 +1,42 @@
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (id, created_at, updated_at, user_id, url, events, secret, failure_count, disabled_at)
+VALUES (
+  gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, 0, NULL
+)
+RETURNING *;
+
+-- name: DeleteWebhookSubscriptionForUser :execrows
+DELETE FROM webhook_subscriptions
+WHERE id = $1 AND user_id = $2;
+
+-- name: EnableWebhookSubscriptionForUser :one
+UPDATE webhook_subscriptions
+SET disabled_at = NULL, failure_count = 0, updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: GetWebhookSubscription :one
+SELECT * FROM webhook_subscriptions
+WHERE id = $1;
+
+-- name: GetWebhookSubscriptionForUser :one
+SELECT * FROM webhook_subscriptions
+WHERE id = $1 AND user_id = $2;
+
+-- name: GetWebhookSubscriptionsForUser :many
+SELECT * FROM webhook_subscriptions
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: RecordWebhookSubscriptionFailure :one
+UPDATE webhook_subscriptions
+SET failure_count = failure_count + 1,
+    disabled_at = CASE WHEN failure_count + 1 >= sqlc.arg('disable_after') THEN NOW() ELSE disabled_at END,
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: ResetWebhookSubscriptionFailures :exec
+UPDATE webhook_subscriptions
+SET failure_count = 0, updated_at = NOW()
+WHERE id = $1 AND failure_count > 0;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE revoked_access_tokens (
+  jti TEXT PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  expires_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE revoked_access_tokens;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE login_failures (
+  kind TEXT NOT NULL,
+  key TEXT NOT NULL,
+  failures INTEGER NOT NULL,
+  last_failure_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (kind, key)
+);
+
+-- +goose Down
+DROP TABLE login_failures;
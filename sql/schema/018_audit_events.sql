@@ -0,0 +1,22 @@
+-- +goose Up
+-- No foreign keys: the trail has to outlive the users it mentions
+CREATE TABLE audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  occurred_at TIMESTAMP NOT NULL,
+  actor_id UUID,
+  action TEXT NOT NULL,
+  target_type TEXT NOT NULL,
+  target_id TEXT NOT NULL,
+  ip_address TEXT NOT NULL,
+  request_id TEXT NOT NULL
+);
+
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id, occurred_at);
+CREATE INDEX audit_events_action_idx ON audit_events (action, occurred_at);
+
+-- Append-only, rows can be added but never changed or removed
+CREATE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING;
+CREATE RULE audit_events_no_delete AS ON DELETE TO audit_events DO INSTEAD NOTHING;
+
+-- +goose Down
+DROP TABLE audit_events;
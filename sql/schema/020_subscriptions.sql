@@ -0,0 +1,25 @@
+-- +goose Up
+-- grace_period_end is when access ends unless the subscription is renewed,
+-- NULL for subscriptions without an end date
+CREATE TABLE subscriptions (
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+  plan TEXT NOT NULL,
+  status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+  current_period_end TIMESTAMP,
+  grace_period_end TIMESTAMP,
+  canceled_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX subscriptions_grace_period_end_idx ON subscriptions (grace_period_end) WHERE status <> 'expired';
+
+-- Existing Chirpy Red users keep it with an open-ended subscription
+INSERT INTO subscriptions (id, user_id, plan, status, current_period_end, grace_period_end, canceled_at, created_at, updated_at)
+SELECT gen_random_uuid(), id, 'chirpy_red', 'active', NULL, NULL, NULL, NOW(), NOW()
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Domain events, written in the same transaction as the change they
+-- describe and published from here by the relay
+CREATE TABLE outbox (
+  id BIGSERIAL PRIMARY KEY,
+  event_id UUID NOT NULL UNIQUE,
+  event_type TEXT NOT NULL,
+  aggregate_type TEXT NOT NULL,
+  aggregate_id TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  occurred_at TIMESTAMP NOT NULL,
+  published_at TIMESTAMP,
+  -- Sinks that already have the event, so one failing sink doesn't make
+  -- the others get it again
+  published_to TEXT NOT NULL,
+  attempts INTEGER NOT NULL,
+  last_error TEXT NOT NULL
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+
+-- +goose Down
+DROP TABLE outbox;
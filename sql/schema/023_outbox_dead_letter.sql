@@ -0,0 +1,15 @@
+-- +goose Up
+-- Events the relay gave up on. They're kept for inspection, and skipped so
+-- they don't hold up the events after them.
+ALTER TABLE outbox ADD COLUMN failed_at TIMESTAMP;
+
+DROP INDEX outbox_unpublished_idx;
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL AND failed_at IS NULL;
+CREATE INDEX outbox_failed_idx ON outbox (id) WHERE failed_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX outbox_failed_idx;
+DROP INDEX outbox_unpublished_idx;
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+
+ALTER TABLE outbox DROP COLUMN failed_at;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- The domain event a delivery carries. The relay may hand the same event
+-- over more than once, the unique index keeps it to one delivery per
+-- endpoint. Older rows have no event ID and don't take part.
+ALTER TABLE webhook_deliveries ADD COLUMN event_id UUID;
+
+CREATE UNIQUE INDEX webhook_deliveries_event_idx ON webhook_deliveries (subscription_id, event_id);
+
+-- +goose Down
+DROP INDEX webhook_deliveries_event_idx;
+
+ALTER TABLE webhook_deliveries DROP COLUMN event_id;
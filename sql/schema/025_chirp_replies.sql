@@ -0,0 +1,8 @@
+-- +goose Up
+-- The chirp a chirp replies to. Replies outlive a deleted parent.
+ALTER TABLE chirps ADD COLUMN reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL;
+
+CREATE INDEX chirps_reply_to_idx ON chirps (reply_to);
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN reply_to;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- When the provider event behind the latest change happened, so an event
+-- that arrives late can't undo a newer one
+ALTER TABLE subscriptions ADD COLUMN last_event_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE subscriptions DROP COLUMN last_event_at;
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"github.com/lib/pq"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/events"
+	"github.com/google/uuid"
+)
+
+// streamChannel is the Postgres channel chirp events are fanned out on, so
+// streams on every instance see them whichever instance relayed them
+const streamChannel = "chirpy_stream"
+
+var hashtagPattern = regexp.MustCompile(`#(\w+)`)
+
+// mentionPattern finds mentions, which name the account's email like a
+// fediverse handle: @alice@example.com
+var mentionPattern = regexp.MustCompile(`@([\w.+-]+@[\w-]+(?:\.[\w-]+)+)`)
+
+// notifyStream passes a chirp event on to every instance's stream hub.
+// It's subscribed to the event bus, so an error has the relay retry it.
+func (cfg *apiConfig) notifyStream(ctx context.Context, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return cfg.dbQueries.Notify(ctx, database.NotifyParams{
+		Channel: streamChannel,
+		Payload: string(payload),
+	})
+}
+
+// listenForStreamEvents feeds chirp events sent by notifyStream, from any
+// instance, into the stream hub
+func (cfg *apiConfig) listenForStreamEvents(dbURL string) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Stream listener: %s", err)
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(streamChannel)
+	if err != nil {
+		log.Printf("Error listening for stream events: %s", err)
+		return
+	}
+
+	for {
+		select {
+		case notification := <-listener.Notify:
+			if notification == nil {
+				// Events sent while the connection was down are gone,
+				// resuming clients get whatever is left in the buffer
+				log.Print("Stream listener reconnected, events may have been missed")
+				continue
+			}
+
+			var event events.Event
+			err := json.Unmarshal([]byte(notification.Extra), &event)
+			if err != nil {
+				log.Printf("Couldn't decode stream event: %s", err)
+				continue
+			}
+			cfg.streamHub.Publish(event)
+		case <-time.After(90 * time.Second):
+			// Notice a dead connection even when it's quiet
+			go listener.Ping()
+		}
+	}
+}
+
+// chirpFilter picks the chirp events a client asked for
+type chirpFilter struct {
+	authorID uuid.UUID // uuid.Nil for any author
+	hashtag  string    // lower case without the #, "" for any
+}
+
+func (f chirpFilter) matches(event events.Event) bool {
+	if f.authorID == uuid.Nil && f.hashtag == "" {
+		return true
+	}
+
+	var chirp Chirp
+	err := json.Unmarshal(event.Data, &chirp)
+	if err != nil {
+		return false
+	}
+	if f.authorID != uuid.Nil && chirp.UserID != f.authorID {
+		return false
+	}
+	if f.hashtag != "" {
+		for _, match := range hashtagPattern.FindAllStringSubmatch(chirp.Body, -1) {
+			if strings.ToLower(match[1]) == f.hashtag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// chirpMentions reports whether body mentions the account with email
+func chirpMentions(body, email string) bool {
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if strings.EqualFold(match[1], email) {
+			return true
+		}
+	}
+	return false
+}
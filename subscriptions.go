@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"github.com/x6Nenko/Chirpy/internal/database"
+	"github.com/x6Nenko/Chirpy/internal/payments"
+	"github.com/google/uuid"
+)
+
+const subscriptionPlanChirpyRed = "chirpy_red"
+
+// subscriptionGracePeriod is how long Chirpy Red survives a failed payment
+// or a missed renewal
+const subscriptionGracePeriod = 7 * 24 * time.Hour
+
+// Subscription is the payload of subscription events
+type Subscription struct {
+	UserID           uuid.UUID  `json:"user_id"`
+	Plan             string     `json:"plan"`
+	Status           string     `json:"status"`
+	CurrentPeriodEnd *time.Time `json:"current_period_end"`
+	GracePeriodEnd   *time.Time `json:"grace_period_end"`
+	CanceledAt       *time.Time `json:"canceled_at"`
+}
+
+func convertSubscription(subscription database.Subscription) Subscription {
+	converted := Subscription{
+		UserID: subscription.UserID,
+		Plan:   subscription.Plan,
+		Status: subscription.Status,
+	}
+	if subscription.CurrentPeriodEnd.Valid {
+		converted.CurrentPeriodEnd = &subscription.CurrentPeriodEnd.Time
+	}
+	if subscription.GracePeriodEnd.Valid {
+		converted.GracePeriodEnd = &subscription.GracePeriodEnd.Time
+	}
+	if subscription.CanceledAt.Valid {
+		converted.CanceledAt = &subscription.CanceledAt.Time
+	}
+	return converted
+}
+
+// applySubscriptionChange moves the user's subscription along and derives
+// is_chirpy_red from the result, recording a "subscription.<kind>" event in
+// the same transaction. Changes that don't apply to the current state, like
+// cancelling an expired subscription or one older than the last change
+// applied, leave it as it is and record nothing (see
+// payments.SubscriptionChange.Applies). It returns sql.ErrNoRows for
+// unknown users.
+func (cfg *apiConfig) applySubscriptionChange(ctx context.Context, change payments.SubscriptionChange) (database.User, error) {
+	var user database.User
+	err := cfg.inTx(ctx, func(q *database.Queries) error {
+		_, err := q.GetUserByID(ctx, change.UserID)
+		if err != nil {
+			return err
+		}
+
+		state := payments.SubscriptionState{}
+		current, err := q.LockSubscriptionForUser(ctx, change.UserID)
+		if err == nil {
+			state.Status = current.Status
+			state.LastChangeAt = current.LastEventAt.Time
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if !change.Applies(state) {
+			user, err = q.SyncUserChirpyRed(ctx, change.UserID)
+			return err
+		}
+
+		occurredAt := sql.NullTime{Time: change.OccurredAt.UTC(), Valid: true}
+		var subscription database.Subscription
+		switch change.Kind {
+		case payments.SubscriptionActivated:
+			params := database.ActivateSubscriptionParams{
+				UserID:      change.UserID,
+				Plan:        change.Plan,
+				LastEventAt: occurredAt,
+			}
+			if params.Plan == "" {
+				params.Plan = subscriptionPlanChirpyRed
+			}
+			if change.CurrentPeriodEnd != nil {
+				periodEnd := change.CurrentPeriodEnd.UTC()
+				params.CurrentPeriodEnd = sql.NullTime{Time: periodEnd, Valid: true}
+				params.GracePeriodEnd = sql.NullTime{Time: periodEnd.Add(subscriptionGracePeriod), Valid: true}
+			}
+			subscription, err = q.ActivateSubscription(ctx, params)
+		case payments.SubscriptionPaymentFailed:
+			subscription, err = q.MarkSubscriptionPastDue(ctx, database.MarkSubscriptionPastDueParams{
+				GracePeriodEnd: sql.NullTime{Time: time.Now().UTC().Add(subscriptionGracePeriod), Valid: true},
+				LastEventAt:    occurredAt,
+				UserID:         change.UserID,
+			})
+		case payments.SubscriptionCanceled:
+			subscription, err = q.CancelSubscription(ctx, database.CancelSubscriptionParams{
+				UserID:      change.UserID,
+				LastEventAt: occurredAt,
+			})
+		case payments.SubscriptionEnded:
+			subscription, err = q.ExpireSubscription(ctx, database.ExpireSubscriptionParams{
+				UserID:      change.UserID,
+				LastEventAt: occurredAt,
+			})
+		default:
+			return errors.New("unknown subscription change " + change.Kind)
+		}
+		if err == nil {
+			err = recordDomainEvent(ctx, q, eventSubscriptionPrefix+change.Kind, aggregateUser, change.UserID.String(), convertSubscription(subscription))
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		user, err = q.SyncUserChirpyRed(ctx, change.UserID)
+		return err
+	})
+	return user, err
+}
+
+// expireLapsedSubscriptions ends subscriptions whose grace period ran out
+// without a renewal and takes Chirpy Red away from their users
+func (cfg *apiConfig) expireLapsedSubscriptions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired := 0
+		err := cfg.inTx(context.Background(), func(q *database.Queries) error {
+			subscriptions, err := q.ExpireLapsedSubscriptions(context.Background())
+			if err != nil {
+				return err
+			}
+
+			for _, subscription := range subscriptions {
+				err = recordDomainEvent(context.Background(), q, eventSubscriptionPrefix+payments.SubscriptionEnded, aggregateUser, subscription.UserID.String(), convertSubscription(subscription))
+				if err != nil {
+					return err
+				}
+				_, err = q.SyncUserChirpyRed(context.Background(), subscription.UserID)
+				if err != nil {
+					return err
+				}
+			}
+			expired = len(subscriptions)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error expiring subscriptions: %s", err)
+			continue
+		}
+		if expired > 0 {
+			log.Printf("Expired %d subscriptions\n", expired)
+		}
+	}
+}